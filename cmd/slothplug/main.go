@@ -0,0 +1,317 @@
+// Command slothplug is the tooling of the sloth plugins of this repository.
+//
+// Usage:
+//
+//	slothplug readme [-check] [-root dir]
+//	slothplug schema [-o file]
+//	slothplug render (-plugin id -opt key=value ... | -spec file -slo name) [-window 5m]
+//	slothplug explain (-plugin id -opt key=value ... | -spec file -slo name)
+//	slothplug lint [-format text|json|sarif] [-warnings-as-errors] path ...
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/viatorinc/sloth-common-metric-plugins/internal/jsonschema"
+	"github.com/viatorinc/sloth-common-metric-plugins/internal/lint"
+	"github.com/viatorinc/sloth-common-metric-plugins/internal/readme"
+	"github.com/viatorinc/sloth-common-metric-plugins/internal/registry"
+	"github.com/viatorinc/sloth-common-metric-plugins/internal/spec"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// command is a subcommand of slothplug, returning the exit code.
+type command struct {
+	name        string
+	description string
+	run         func(args []string, stdout io.Writer, stderr io.Writer) int
+}
+
+func commands() []command {
+	return []command{
+		{name: "readme", description: "rewrites the generated sections of the plugin READMEs", run: runReadme},
+		{name: "schema", description: "writes the JSON Schema of sloth SLO specs using the plugins", run: runSchema},
+		{name: "render", description: "prints the query of a plugin for options or an SLO of a spec", run: runRender},
+		{name: "explain", description: "prints a human-readable explanation of the SLI of options or an SLO of a spec",
+			run: runExplain},
+		{name: "lint", description: "validates the plugin options of the SLO spec files of directories", run: runLint},
+	}
+}
+
+func run(args []string, stdout io.Writer, stderr io.Writer) int {
+	if len(args) > 0 {
+		for _, cmd := range commands() {
+			if cmd.name == args[0] {
+				return cmd.run(args[1:], stdout, stderr)
+			}
+		}
+		fmt.Fprintf(stderr, "unknown command '%s'\n", args[0])
+	}
+
+	fmt.Fprintln(stderr, "usage: slothplug <command> [flags]")
+	for _, cmd := range commands() {
+		fmt.Fprintf(stderr, "  %-10s %s\n", cmd.name, cmd.description)
+	}
+	return 2
+}
+
+func runReadme(args []string, stdout io.Writer, stderr io.Writer) int {
+	flags := flag.NewFlagSet("readme", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	check := flags.Bool("check", false, "fails when a README is out of date instead of rewriting it")
+	root := flags.String("root", ".", "the repository root")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	exitCode := 0
+	for _, plugin := range registry.Plugins() {
+		path := filepath.Join(*root, plugin.Dir, "README.md")
+		content, err := os.ReadFile(path)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+
+		updated, err := readme.Update(string(content), plugin)
+		if err != nil {
+			fmt.Fprintf(stderr, "%s: %v\n", path, err)
+			return 1
+		}
+		if updated == string(content) {
+			continue
+		}
+
+		if *check {
+			fmt.Fprintf(stderr, "%s is out of date, run `go run ./cmd/slothplug readme`\n", path)
+			exitCode = 1
+			continue
+		}
+		if err := os.WriteFile(path, []byte(updated), 0o644); err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+		fmt.Fprintf(stdout, "updated %s\n", path)
+	}
+	return exitCode
+}
+
+func runSchema(args []string, stdout io.Writer, stderr io.Writer) int {
+	flags := flag.NewFlagSet("schema", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	output := flags.String("o", "", "the file to write the schema to, defaults to stdout")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	schema, err := json.MarshalIndent(jsonschema.Generate(registry.Plugins()), "", "  ")
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	schema = append(schema, '\n')
+
+	if *output == "" {
+		_, err = stdout.Write(schema)
+	} else {
+		err = os.WriteFile(*output, schema, 0o644)
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// windowPlaceholder is the placeholder of the SLI window, filled in by sloth.
+const windowPlaceholder = "{{.window}}"
+
+var regxPrometheusDuration = regexp.MustCompile(`^([0-9]+(ms|s|m|h|d|w|y))+$`)
+
+// optionFlags collects repeated `-opt key=value` flags.
+type optionFlags map[string]string
+
+func (o optionFlags) String() string {
+	return fmt.Sprint(map[string]string(o))
+}
+
+func (o optionFlags) Set(value string) error {
+	key, optionValue, found := strings.Cut(value, "=")
+	if !found || key == "" {
+		return fmt.Errorf("expected key=value, got '%s'", value)
+	}
+	o[key] = optionValue
+	return nil
+}
+
+// pluginFlags are the flags selecting a plugin and its options, either directly or from an SLO of a spec file.
+type pluginFlags struct {
+	pluginID *string
+	options  optionFlags
+	specFile *string
+	sloName  *string
+}
+
+func addPluginFlags(flags *flag.FlagSet) *pluginFlags {
+	p := &pluginFlags{options: optionFlags{}}
+	p.pluginID = flags.String("plugin", "", "the ID of the plugin, one of "+strings.Join(registry.IDs(), ", "))
+	flags.Var(p.options, "opt", "a plugin option as key=value, can be repeated")
+	p.specFile = flags.String("spec", "", "a sloth SLO spec file to take the plugin and options from, instead of -plugin and -opt")
+	p.sloName = flags.String("slo", "", "the name of the SLO of the spec file")
+	return p
+}
+
+// pluginInput is what sloth passes to the plugin of an SLO, the metadata and labels are only known for SLOs of a spec.
+type pluginInput struct {
+	meta    map[string]string
+	labels  map[string]string
+	options map[string]string
+}
+
+// resolve returns the selected plugin and its input, or the exit code when they can not be resolved.
+func (p *pluginFlags) resolve(stderr io.Writer) (registry.Plugin, pluginInput, int) {
+	pluginID, input := *p.pluginID, pluginInput{options: p.options}
+	switch {
+	case *p.specFile != "" && (pluginID != "" || len(input.options) > 0):
+		fmt.Fprintln(stderr, "either -spec or -plugin with -opt can be used")
+		return registry.Plugin{}, pluginInput{}, 2
+	case *p.specFile != "":
+		if *p.sloName == "" {
+			fmt.Fprintln(stderr, "-slo is required with -spec")
+			return registry.Plugin{}, pluginInput{}, 2
+		}
+		content, err := os.ReadFile(*p.specFile)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return registry.Plugin{}, pluginInput{}, 1
+		}
+		specs, err := spec.ParseAll(content)
+		if err != nil {
+			fmt.Fprintf(stderr, "%s: %v\n", *p.specFile, err)
+			return registry.Plugin{}, pluginInput{}, 1
+		}
+		parsed, slo, ok := spec.FindSLO(specs, *p.sloName)
+		if !ok {
+			fmt.Fprintf(stderr, "%s: SLO '%s' not found\n", *p.specFile, *p.sloName)
+			return registry.Plugin{}, pluginInput{}, 1
+		}
+		pluginID = slo.PluginID
+		input = pluginInput{meta: parsed.Meta(slo), labels: slo.Labels, options: slo.Options}
+	case pluginID == "":
+		fmt.Fprintln(stderr, "either -spec or -plugin is required")
+		return registry.Plugin{}, pluginInput{}, 2
+	}
+
+	plugin, ok := registry.Lookup(pluginID)
+	if !ok {
+		fmt.Fprintf(stderr, "unknown plugin '%s', expected one of %s\n", pluginID, strings.Join(registry.IDs(), ", "))
+		return registry.Plugin{}, pluginInput{}, 1
+	}
+	return plugin, input, 0
+}
+
+func runRender(args []string, stdout io.Writer, stderr io.Writer) int {
+	flags := flag.NewFlagSet("render", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	selected := addPluginFlags(flags)
+	window := flags.String("window", "", "a window substituted for "+windowPlaceholder+", e.g. 5m")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	if *window != "" && !regxPrometheusDuration.MatchString(*window) {
+		fmt.Fprintf(stderr, "invalid window '%s', expected a Prometheus duration, e.g. 5m\n", *window)
+		return 2
+	}
+
+	plugin, input, exitCode := selected.resolve(stderr)
+	if exitCode != 0 {
+		return exitCode
+	}
+
+	query, err := plugin.Render(input.meta, input.labels, input.options)
+	if err != nil {
+		fmt.Fprintf(stderr, "%s: %v\n", plugin.ID, err)
+		return 1
+	}
+	if *window != "" {
+		query = strings.ReplaceAll(query, windowPlaceholder, *window)
+	}
+	fmt.Fprintln(stdout, strings.TrimSpace(query))
+	return 0
+}
+
+func runExplain(args []string, stdout io.Writer, stderr io.Writer) int {
+	flags := flag.NewFlagSet("explain", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	selected := addPluginFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	plugin, input, exitCode := selected.resolve(stderr)
+	if exitCode != 0 {
+		return exitCode
+	}
+
+	explanation, err := plugin.Explain(input.meta, input.labels, input.options)
+	if err != nil {
+		fmt.Fprintf(stderr, "%s: %v\n", plugin.ID, err)
+		return 1
+	}
+	fmt.Fprintln(stdout, explanation)
+	return 0
+}
+
+func runLint(args []string, stdout io.Writer, stderr io.Writer) int {
+	flags := flag.NewFlagSet("lint", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	format := flags.String("format", "text", "the output format, one of text, json or sarif")
+	warningsAsErrors := flags.Bool("warnings-as-errors", false, "fails on advisories as well as on errors")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	writers := map[string]func(io.Writer, []lint.Result) error{
+		"text": lint.WriteText, "json": lint.WriteJSON, "sarif": lint.WriteSARIF,
+	}
+	write, ok := writers[*format]
+	if !ok {
+		fmt.Fprintf(stderr, "unknown format '%s', expected one of text, json or sarif\n", *format)
+		return 2
+	}
+	if flags.NArg() == 0 {
+		fmt.Fprintln(stderr, "at least one spec file or directory is required")
+		return 2
+	}
+
+	files, err := lint.SpecFiles(flags.Args())
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	results, err := lint.Files(files)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	if err := write(stdout, results); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	if lint.HasErrors(results, *warningsAsErrors) {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunRender(t *testing.T) {
+	tests := map[string]struct {
+		args        []string
+		expExitCode int
+		expStdout   []string
+		expStderr   string
+	}{
+		"Options should be rendered with the window.": {
+			args: []string{"-plugin", "viator-sloth-plugins/request_elapsed_time_ms/availability",
+				"-opt", "servicename=demandproduct", "-opt", "apm_tx=/product/full", "-window", "5m"},
+			expStdout: []string{`RESPONSE_STATUS=~"2.."}[5m]`, `APM_TRANSACTION="/product/full"}[5m]`},
+		},
+		"Latency options should be rendered with the window.": {
+			args: []string{"-plugin", "viator-sloth-plugins/request_elapsed_time_ms/latency",
+				"-opt", "servicename=demandproduct", "-opt", "apm_tx=/product/full", "-opt", "latency=150", "-window", "5m"},
+			expStdout: []string{`le="100.0"}[5m]`, `le="250.0"}[5m]`, `APM_TRANSACTION="/product/full"}[5m]`},
+		},
+		"An SLO of a spec should be rendered.": {
+			args: []string{"-spec", "../../test/integration/request-elapsed_time_ms-latency.yml",
+				"-slo", "test-exact-bucket"},
+			expStdout: []string{"request:ELAPSED_TIME_MS_bucket{", "[{{.window}}]"},
+		},
+		"A missing SLO should fail.": {
+			args:        []string{"-spec", "../../test/integration/request-elapsed_time_ms-latency.yml", "-slo", "missing"},
+			expExitCode: 1,
+			expStderr:   "SLO 'missing' not found",
+		},
+		"Invalid options should fail.": {
+			args:        []string{"-plugin", "viator-sloth-plugins/request_elapsed_time_ms/availability"},
+			expExitCode: 1,
+			expStderr:   "option 'servicename': missing mandatory option",
+		},
+		"An unknown plugin should fail.": {
+			args:        []string{"-plugin", "unknown", "-opt", "servicename=demandproduct"},
+			expExitCode: 1,
+			expStderr:   "unknown plugin 'unknown'",
+		},
+		"An invalid window should fail.": {
+			args:        []string{"-plugin", "unknown", "-window", "5 minutes"},
+			expExitCode: 2,
+			expStderr:   "invalid window '5 minutes'",
+		},
+		"Spec and options should not be mixed.": {
+			args:        []string{"-spec", "spec.yml", "-slo", "slo", "-opt", "servicename=demandproduct"},
+			expExitCode: 2,
+			expStderr:   "either -spec or -plugin with -opt can be used",
+		},
+		"Options without a value should fail.": {
+			args:        []string{"-opt", "servicename"},
+			expExitCode: 2,
+			expStderr:   "expected key=value, got 'servicename'",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			exitCode := run(append([]string{"render"}, test.args...), &stdout, &stderr)
+
+			assert.Equal(t, test.expExitCode, exitCode, stderr.String())
+			for _, expected := range test.expStdout {
+				assert.Contains(t, stdout.String(), expected)
+			}
+			assert.True(t, strings.Contains(stderr.String(), test.expStderr), stderr.String())
+		})
+	}
+}
+
+func TestRunExplain(t *testing.T) {
+	tests := map[string]struct {
+		args        []string
+		expExitCode int
+		expStdout   string
+		expStderr   string
+	}{
+		"Options should be explained.": {
+			args: []string{"-plugin", "viator-sloth-plugins/request_elapsed_time_ms/availability",
+				"-opt", "servicename=demandproduct", "-opt", "apm_tx=/product/full"},
+			expStdout: "Good = requests to demandproduct-metrics, APM_TRANSACTION=/product/full; good: 2xx; " +
+				"Total = the same requests with any status; without requests in the window the SLI reports no errors.\n",
+		},
+		"An SLO of a spec should be explained.": {
+			args: []string{"-spec", "../../test/integration/request-elapsed_time_ms-latency.yml",
+				"-slo", "test-non-bucket-latency"},
+			expStdout: "Good = requests to demandproduct-metrics, APM_TRANSACTION=/product/full, " +
+				"with latency ≤150ms (estimated between the 100ms and 250ms buckets at ratio 0.33); good: 2xx; " +
+				"Total = the same requests with any status and latency; " +
+				"without requests in the window the SLI reports no errors.\n",
+		},
+		"Invalid options should fail.": {
+			args: []string{"-plugin", "viator-sloth-plugins/request_elapsed_time_ms/latency",
+				"-opt", "servicename=demandproduct"},
+			expExitCode: 1,
+			expStderr:   "option 'latency': missing mandatory option",
+		},
+		"A plugin should be required.": {
+			args:        []string{"-opt", "servicename=demandproduct"},
+			expExitCode: 2,
+			expStderr:   "either -spec or -plugin is required",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			exitCode := run(append([]string{"explain"}, test.args...), &stdout, &stderr)
+
+			assert.Equal(t, test.expExitCode, exitCode, stderr.String())
+			assert.Equal(t, test.expStdout, stdout.String())
+			assert.True(t, strings.Contains(stderr.String(), test.expStderr), stderr.String())
+		})
+	}
+}
+
+func TestRunLint(t *testing.T) {
+	tests := map[string]struct {
+		args        []string
+		expExitCode int
+		expStdout   string
+	}{
+		"Specs with advisories only should pass.": {
+			args:      []string{"../../test/integration"},
+			expStdout: "request-elapsed_time_ms-latency.yml:25: warning: [latency-far-from-bucket]",
+		},
+		"Advisories should fail when treated as errors.": {
+			args:        []string{"-warnings-as-errors", "-format", "json", "../../test/integration"},
+			expExitCode: 1,
+			expStdout:   `"code": "missing-apm-tx"`,
+		},
+		"An unknown format should fail.": {
+			args:        []string{"-format", "xml", "../../test/integration"},
+			expExitCode: 2,
+		},
+		"Missing paths should fail.": {
+			args:        []string{},
+			expExitCode: 2,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			exitCode := run(append([]string{"lint"}, test.args...), &stdout, &stderr)
+
+			assert.Equal(t, test.expExitCode, exitCode, stderr.String())
+			assert.Contains(t, stdout.String(), test.expStdout)
+		})
+	}
+}
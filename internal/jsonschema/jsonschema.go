@@ -0,0 +1,184 @@
+// Package jsonschema derives a JSON Schema of sloth SLO specs from the option schema of the plugins,
+// validating `sli.plugin.options` for the plugins of this repository.
+package jsonschema
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/viatorinc/sloth-common-metric-plugins/internal/registry"
+)
+
+// Draft is the JSON Schema draft of the generated schema, supported by most editors.
+const Draft = "http://json-schema.org/draft-07/schema#"
+
+// boolSpellings are spellings of booleans, including the YAML ones, boolPattern keeps those the plugins accept.
+var boolSpellings = []string{"true", "false", "True", "False", "TRUE", "FALSE", "t", "f", "T", "F", "1", "0",
+	"yes", "no", "Yes", "No", "on", "off", "y", "n"}
+
+// BoolPattern returns the pattern of the strings the plugins parse as one of the values: like GetBoolOption of the
+// plugins, the spellings accepted by strconv.ParseBool surrounded by whitespace, and blank values with allowBlank.
+func BoolPattern(allowBlank bool, values ...bool) string {
+	var accepted []string
+	for _, spelling := range boolSpellings {
+		value, err := strconv.ParseBool(spelling)
+		if err != nil {
+			continue
+		}
+		for _, v := range values {
+			if v == value {
+				accepted = append(accepted, regexp.QuoteMeta(spelling))
+			}
+		}
+	}
+
+	optional := ""
+	if allowBlank {
+		optional = "?"
+	}
+	return `^\s*(` + strings.Join(accepted, "|") + `)` + optional + `\s*$`
+}
+
+// Object is a JSON Schema object.
+type Object map[string]interface{}
+
+// Generate returns the JSON Schema of sloth SLO specs, with the options of every plugin.
+// Plugins of other repositories are accepted without validating their options.
+func Generate(plugins []registry.Plugin) Object {
+	definitions := Object{}
+	var pluginOptions []interface{}
+	for _, plugin := range plugins {
+		name := DefinitionName(plugin.ID)
+		definitions[name] = Options(plugin)
+		pluginOptions = append(pluginOptions, Object{
+			"if": Object{
+				"properties": Object{"id": Object{"const": plugin.ID}},
+				"required":   []string{"id"},
+			},
+			"then": Object{
+				"properties": Object{"options": Object{"$ref": "#/definitions/" + name}},
+				"required":   []string{"options"},
+			},
+		})
+	}
+
+	definitions["plugin"] = Object{
+		"type":     "object",
+		"required": []string{"id"},
+		"properties": Object{
+			"id": Object{
+				"type":        "string",
+				"description": "the ID of the SLI plugin",
+				"examples":    registry.IDs(),
+			},
+			"options": Object{"type": "object", "additionalProperties": Object{"type": []string{"string", "number", "boolean"}}},
+		},
+		"allOf": pluginOptions,
+	}
+
+	return Object{
+		"$schema":     Draft,
+		"title":       "sloth SLO spec",
+		"description": "sloth prometheus/v1 SLO spec, validating the options of the plugins: " + strings.Join(registry.IDs(), ", "),
+		"type":        "object",
+		"required":    []string{"version", "service", "slos"},
+		"properties": Object{
+			"version": Object{"type": "string", "const": "prometheus/v1"},
+			"service": Object{"type": "string"},
+			"labels":  Object{"type": "object", "additionalProperties": Object{"type": "string"}},
+			"slos": Object{
+				"type": "array",
+				"items": Object{
+					"type":     "object",
+					"required": []string{"name", "objective", "sli"},
+					"properties": Object{
+						"name":      Object{"type": "string"},
+						"objective": Object{"type": "number", "exclusiveMinimum": 0, "maximum": 100},
+						"sli": Object{
+							"type":       "object",
+							"properties": Object{"plugin": Object{"$ref": "#/definitions/plugin"}},
+						},
+					},
+				},
+			},
+		},
+		"definitions": definitions,
+	}
+}
+
+// DefinitionName returns the name of the definition of the plugin options.
+func DefinitionName(id string) string {
+	return strings.NewReplacer("/", ".", "~", ".").Replace(id)
+}
+
+// Options returns the JSON Schema of the options of a plugin.
+// Unknown options are rejected, unless `allow_unknown_options` is set.
+func Options(plugin registry.Plugin) Object {
+	properties := Object{}
+	var names []interface{}
+	var required []string
+	for _, option := range plugin.Options {
+		properties[option.Name] = Option(option)
+		names = append(names, option.Name)
+		if option.Mandatory {
+			required = append(required, option.Name)
+		}
+	}
+
+	options := Object{
+		"type":       "object",
+		"properties": properties,
+		"if": Object{
+			"properties": Object{"allow_unknown_options": Object{"anyOf": []interface{}{
+				Object{"const": true},
+				Object{"type": "string", "pattern": BoolPattern(false, true)},
+			}}},
+			"required": []string{"allow_unknown_options"},
+		},
+		"else": Object{"propertyNames": Object{"enum": names}},
+	}
+	if len(required) > 0 {
+		options["required"] = required
+	}
+	return options
+}
+
+// Option returns the JSON Schema of a single option.
+// sloth passes all options as strings, so numbers and booleans are accepted unquoted as well as quoted.
+// Regexes are RE2 regexes, which the `regex` format of JSON Schema (ECMA-262) would reject in part, e.g. `(?i)`.
+func Option(option registry.Option) Object {
+	schema := Object{"description": option.Description}
+	if option.Example != "" {
+		schema["examples"] = []string{option.Example}
+	}
+	if option.Default != "" {
+		schema["default"] = option.Default
+	}
+
+	switch option.Type {
+	case "bool":
+		schema["type"] = []string{"boolean", "string"}
+		schema["pattern"] = BoolPattern(true, true, false)
+	case "int":
+		schema["type"] = []string{"integer", "string"}
+		schema["pattern"] = `^\s*[0-9]+\s*$`
+		if option.Maximum != 0 {
+			schema["minimum"] = option.Minimum
+			schema["maximum"] = option.Maximum
+		}
+	case "regex":
+		schema["type"] = "string"
+		schema["description"] = option.Description + " (RE2 syntax, fully anchored by Prometheus)"
+	default:
+		schema["type"] = "string"
+	}
+
+	if option.Pattern != "" {
+		schema["pattern"] = option.Pattern
+	}
+	if len(option.AllowedValues) > 0 {
+		schema["enum"] = option.AllowedValues
+	}
+	return schema
+}
@@ -0,0 +1,143 @@
+package jsonschema_test
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/viatorinc/sloth-common-metric-plugins/internal/jsonschema"
+	"github.com/viatorinc/sloth-common-metric-plugins/internal/registry"
+	"github.com/viatorinc/sloth-common-metric-plugins/plugins/request_elapsed_time_ms/availability"
+	"github.com/viatorinc/sloth-common-metric-plugins/plugins/request_elapsed_time_ms/latency"
+	"gopkg.in/yaml.v3"
+)
+
+func TestOption(t *testing.T) {
+	tests := map[string]struct {
+		option registry.Option
+		exp    jsonschema.Object
+	}{
+		"Strings with a pattern should be validated by the pattern.": {
+			option: registry.Option{Name: "servicename", Type: "string", Pattern: "^[a-z]+$", Description: "d", Example: "e"},
+			exp: jsonschema.Object{"type": "string", "pattern": "^[a-z]+$", "description": "d",
+				"examples": []string{"e"}},
+		},
+		"Booleans should be booleans or strings of the accepted values.": {
+			option: registry.Option{Name: "enabled", Type: "bool", Default: "true", Description: "d"},
+			exp: jsonschema.Object{"type": []string{"boolean", "string"}, "description": "d", "default": "true",
+				"pattern": `^\s*(true|false|True|False|TRUE|FALSE|t|f|T|F|1|0)?\s*$`},
+		},
+		"Integers should be bound by their range.": {
+			option: registry.Option{Name: "latency", Type: "int", Minimum: 1, Maximum: 10, Description: "d"},
+			exp: jsonschema.Object{"type": []string{"integer", "string"}, "pattern": `^\s*[0-9]+\s*$`,
+				"minimum": 1, "maximum": 10, "description": "d"},
+		},
+		"Allowed values should be enums.": {
+			option: registry.Option{Name: "mode", Type: "string", AllowedValues: []string{"a", "b"}, Description: "d"},
+			exp:    jsonschema.Object{"type": "string", "enum": []string{"a", "b"}, "description": "d"},
+		},
+		"Regexes should be described as RE2 instead of the ECMA-262 regex format.": {
+			option: registry.Option{Name: "regex", Type: "regex", Description: "d"},
+			exp:    jsonschema.Object{"type": "string", "description": "d (RE2 syntax, fully anchored by Prometheus)"},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, test.exp, jsonschema.Option(test.option))
+		})
+	}
+}
+
+func TestBoolPattern(t *testing.T) {
+	asserts := assert.New(t)
+
+	pattern := regexp.MustCompile(jsonschema.BoolPattern(true, true, false))
+	truePattern := regexp.MustCompile(jsonschema.BoolPattern(false, true))
+	for _, value := range []string{"true", "false", " TRUE ", "1", "0", "t", "F", "False", "", " ", "yes", "no",
+		"on", "tRuE", "2", "truee"} {
+		options := map[string]string{"allow_unknown_options": value}
+		parsed, err := availability.GetBoolOption(options, "allow_unknown_options", false)
+		asserts.Equal(err == nil, pattern.MatchString(value), "'%s' should match when the plugins accept it", value)
+		asserts.Equal(err == nil && parsed, truePattern.MatchString(value),
+			"'%s' should match the true values when the plugins parse it as true", value)
+	}
+}
+
+func TestGenerateCoversIntegrationSpecs(t *testing.T) {
+	asserts := assert.New(t)
+
+	schema, err := json.Marshal(jsonschema.Generate(registry.Plugins()))
+	if !asserts.NoError(err) {
+		return
+	}
+	var generated struct {
+		Definitions map[string]struct {
+			Properties map[string]interface{} `json:"properties"`
+			Required   []string               `json:"required"`
+		} `json:"definitions"`
+	}
+	asserts.NoError(json.Unmarshal(schema, &generated))
+
+	files, _ := filepath.Glob(filepath.Join("..", "..", "test", "integration", "*.yml"))
+	asserts.NotEmpty(files)
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		asserts.NoError(err)
+		var spec struct {
+			SLOs []struct {
+				SLI struct {
+					Plugin struct {
+						ID      string            `yaml:"id"`
+						Options map[string]string `yaml:"options"`
+					} `yaml:"plugin"`
+				} `yaml:"sli"`
+			} `yaml:"slos"`
+		}
+		asserts.NoError(yaml.Unmarshal(content, &spec))
+
+		for _, slo := range spec.SLOs {
+			definition, ok := generated.Definitions[jsonschema.DefinitionName(slo.SLI.Plugin.ID)]
+			if !asserts.True(ok, slo.SLI.Plugin.ID) {
+				continue
+			}
+			for option := range slo.SLI.Plugin.Options {
+				asserts.Contains(definition.Properties, option, file)
+			}
+			for _, option := range definition.Required {
+				asserts.Contains(slo.SLI.Plugin.Options, option, file)
+			}
+		}
+	}
+}
+
+func TestGenerateLatencyOptions(t *testing.T) {
+	asserts := assert.New(t)
+
+	schema, err := json.Marshal(jsonschema.Generate(registry.Plugins()))
+	if !asserts.NoError(err) {
+		return
+	}
+	var generated struct {
+		Definitions map[string]struct {
+			Properties map[string]struct {
+				Minimum int      `json:"minimum"`
+				Maximum int      `json:"maximum"`
+				Enum    []string `json:"enum"`
+			} `json:"properties"`
+			Required []string `json:"required"`
+		} `json:"definitions"`
+	}
+	asserts.NoError(json.Unmarshal(schema, &generated))
+
+	definition := generated.Definitions[jsonschema.DefinitionName(latency.SLIPluginID)]
+	asserts.Equal([]string{"latency"}, definition.Required)
+	asserts.Equal(latency.LowestBucket, definition.Properties["latency"].Minimum)
+	asserts.Equal(latency.TopBucket, definition.Properties["latency"].Maximum)
+	for _, profile := range latency.MetricProfiles {
+		asserts.Contains(definition.Properties["metric_profile"].Enum, profile.Name)
+	}
+}
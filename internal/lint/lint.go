@@ -0,0 +1,188 @@
+// Package lint validates the plugin options of sloth SLO spec files offline, without a sloth binary.
+package lint
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/viatorinc/sloth-common-metric-plugins/internal/registry"
+	"github.com/viatorinc/sloth-common-metric-plugins/internal/spec"
+)
+
+// PluginIDPrefix is the prefix of the IDs of the plugins of this repository, other plugins are not linted.
+const PluginIDPrefix = "viator-sloth-plugins/"
+
+// Result is a finding located in a spec file.
+type Result struct {
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+	SLO      string `json:"slo,omitempty"`
+	PluginID string `json:"plugin,omitempty"`
+	Severity string `json:"severity"`
+	Code     string `json:"code"`
+	Option   string `json:"option,omitempty"`
+	Message  string `json:"message"`
+}
+
+// SpecFiles returns the YAML files of the paths, walking directories recursively.
+func SpecFiles(paths []string) ([]string, error) {
+	var files []string
+	for _, path := range paths {
+		err := filepath.Walk(path, func(file string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if ext := filepath.Ext(file); !info.IsDir() && (ext == ".yml" || ext == ".yaml") {
+				files = append(files, file)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// File returns the results of the specs of a file, which can contain several `---` separated documents.
+// Documents that are not sloth prometheus/v1 specs are skipped.
+func File(file string, content []byte) []Result {
+	specs, err := spec.ParseAll(content)
+	if err != nil {
+		return []Result{{File: file, Line: 1, Severity: registry.SeverityError, Code: "invalid-spec",
+			Message: err.Error()}}
+	}
+
+	var results []Result
+	for _, parsed := range specs {
+		results = append(results, specResults(file, parsed)...)
+	}
+	return results
+}
+
+// specResults returns the results of a spec of a file.
+func specResults(file string, parsed spec.Spec) []Result {
+	var results []Result
+	for _, slo := range parsed.SLOs {
+		if !strings.HasPrefix(slo.PluginID, PluginIDPrefix) {
+			continue
+		}
+
+		plugin, ok := registry.Lookup(slo.PluginID)
+		if !ok {
+			results = append(results, Result{File: file, Line: slo.Line, SLO: slo.Name, PluginID: slo.PluginID,
+				Severity: registry.SeverityError, Code: "unknown-plugin",
+				Message: fmt.Sprintf("unknown plugin, expected one of %s", strings.Join(registry.IDs(), ", "))})
+			continue
+		}
+
+		for _, finding := range plugin.Check(parsed.Meta(slo), slo.Labels, slo.Options) {
+			line, ok := slo.OptionLines[finding.Option]
+			if !ok {
+				line = slo.Line
+			}
+			results = append(results, Result{File: file, Line: line, SLO: slo.Name, PluginID: slo.PluginID,
+				Severity: finding.Severity, Code: finding.Code, Option: finding.Option, Message: finding.Message})
+		}
+	}
+	return results
+}
+
+// Files returns the results of all spec files.
+func Files(files []string) ([]Result, error) {
+	var results []Result
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, File(file, content)...)
+	}
+	return results, nil
+}
+
+// HasErrors returns whether any result is an error, or a warning when warnings are treated as errors.
+func HasErrors(results []Result, warningsAsErrors bool) bool {
+	for _, result := range results {
+		if result.Severity == registry.SeverityError || warningsAsErrors {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteText writes the results in the `file:line: severity: message` format of compilers.
+func WriteText(w io.Writer, results []Result) error {
+	for _, result := range results {
+		slo := ""
+		if result.SLO != "" {
+			slo = fmt.Sprintf("slo '%s': ", result.SLO)
+		}
+		if _, err := fmt.Fprintf(w, "%s:%d: %s: [%s] %s%s\n", result.File, result.Line, result.Severity, result.Code,
+			slo, result.Message); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteJSON writes the results as a JSON array.
+func WriteJSON(w io.Writer, results []Result) error {
+	if results == nil {
+		results = []Result{}
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(results)
+}
+
+// WriteSARIF writes the results as a SARIF 2.1.0 log, e.g. for code scanning in CI.
+func WriteSARIF(w io.Writer, results []Result) error {
+	sarifResults := []interface{}{}
+	var rules []interface{}
+	ruleIDs := map[string]bool{}
+	for _, result := range results {
+		if !ruleIDs[result.Code] {
+			ruleIDs[result.Code] = true
+			rules = append(rules, map[string]interface{}{"id": result.Code})
+		}
+
+		message := result.Message
+		if result.SLO != "" {
+			message = fmt.Sprintf("slo '%s': %s", result.SLO, message)
+		}
+		sarifResults = append(sarifResults, map[string]interface{}{
+			"ruleId":  result.Code,
+			"level":   result.Severity,
+			"message": map[string]interface{}{"text": message},
+			"locations": []interface{}{map[string]interface{}{
+				"physicalLocation": map[string]interface{}{
+					"artifactLocation": map[string]interface{}{"uri": filepath.ToSlash(result.File)},
+					"region":           map[string]interface{}{"startLine": result.Line},
+				},
+			}},
+		})
+	}
+
+	driver := map[string]interface{}{"name": "slothplug", "informationUri": "https://sloth.dev"}
+	if len(rules) > 0 {
+		driver["rules"] = rules
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(map[string]interface{}{
+		"version": "2.1.0",
+		"$schema": "https://json.schemastore.org/sarif-2.1.0.json",
+		"runs": []interface{}{map[string]interface{}{
+			"tool":    map[string]interface{}{"driver": driver},
+			"results": sarifResults,
+		}},
+	})
+}
@@ -0,0 +1,184 @@
+package lint_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/viatorinc/sloth-common-metric-plugins/internal/lint"
+)
+
+const invalidSpec = `version: "prometheus/v1"
+service: "demandproduct"
+slos:
+  - name: "latency"
+    objective: 99.9
+    sli:
+      plugin:
+        id: "viator-sloth-plugins/request_elapsed_time_ms/latency"
+        options:
+          servicename: "demandproduct"
+          apm_tx: "/product/full"
+          latency: "fast"
+  - name: "other plugin"
+    sli:
+      plugin:
+        id: "sloth-common/kubernetes/apiserver/availability"
+        options:
+          unknown: "option"
+  - name: "unknown"
+    sli:
+      plugin:
+        id: "viator-sloth-plugins/unknown"
+`
+
+func TestFile(t *testing.T) {
+	tests := map[string]struct {
+		content string
+		exp     []lint.Result
+	}{
+		"Findings should be located at their option or SLO.": {
+			content: invalidSpec,
+			exp: []lint.Result{
+				{File: "spec.yml", Line: 12, SLO: "latency", PluginID: "viator-sloth-plugins/request_elapsed_time_ms/latency",
+					Severity: "error", Code: "invalid-value", Option: "latency",
+					Message: "option 'latency' with value 'fast': invalid value: " +
+						"needs to be a number between the lowest bucket 5 and the highest bucket 500000"},
+				{File: "spec.yml", Line: 19, SLO: "unknown", PluginID: "viator-sloth-plugins/unknown",
+					Severity: "error", Code: "unknown-plugin", Message: "unknown plugin, expected one of " +
+						"viator-sloth-plugins/request_elapsed_time_ms/availability, " +
+						"viator-sloth-plugins/request_elapsed_time_ms/latency"},
+			},
+		},
+		"Advisories should be warnings.": {
+			content: `version: "prometheus/v1"
+slos:
+  - name: "availability"
+    sli:
+      plugin:
+        id: "viator-sloth-plugins/request_elapsed_time_ms/availability"
+        options:
+          servicename: "demandproduct"
+`,
+			exp: []lint.Result{{File: "spec.yml", Line: 3, SLO: "availability",
+				PluginID: "viator-sloth-plugins/request_elapsed_time_ms/availability", Severity: "warning",
+				Code: "missing-apm-tx", Option: "apm_tx", Message: "option 'apm_tx': is not set, " +
+					"an SLO should usually be limited to the APM_TRANSACTIONs of a single use case"}},
+		},
+		"Other documents should be skipped.": {
+			content: "apiVersion: v1\nkind: ConfigMap\n",
+		},
+		"Invalid YAML should fail.": {
+			content: "slos: [",
+			exp: []lint.Result{{File: "spec.yml", Line: 1, Severity: "error", Code: "invalid-spec",
+				Message: "yaml: line 1: did not find expected node content"}},
+		},
+		"The service and labels of the spec should be passed to the plugin.": {
+			content: `version: "prometheus/v1"
+service: "demandproduct"
+labels:
+  env: "prod"
+slos:
+  - name: "availability"
+    sli:
+      plugin:
+        id: "viator-sloth-plugins/request_elapsed_time_ms/availability"
+        options:
+          apm_tx: "/product/full"
+          filter_from_labels: "env,region"
+`,
+			exp: []lint.Result{{File: "spec.yml", Line: 12, SLO: "availability",
+				PluginID: "viator-sloth-plugins/request_elapsed_time_ms/availability", Severity: "error",
+				Code: "missing-SLO-label", Option: "filter_from_labels",
+				Message: "option 'filter_from_labels' with value 'env,region': missing SLO label: " +
+					"the spec does not have the label 'region'"}},
+		},
+		"Every spec of a multi-document file should be linted.": {
+			content: `apiVersion: v1
+kind: ConfigMap
+---
+- not
+- a spec
+---
+version: "prometheus/v1"
+slos:
+  - name: "first"
+    sli:
+      plugin:
+        id: "viator-sloth-plugins/request_elapsed_time_ms/availability"
+        options:
+          servicename: "demandproduct"
+          apm_tx: "/product/full"
+---
+version: "prometheus/v1"
+slos:
+  - name: "second"
+    sli:
+      plugin:
+        id: "viator-sloth-plugins/request_elapsed_time_ms/availability"
+        options:
+          servicename: "demandproduct"
+          apm_tx: "/product/full"
+          good_http_status_regex: "([xyz"
+`,
+			exp: []lint.Result{{File: "spec.yml", Line: 26, SLO: "second",
+				PluginID: "viator-sloth-plugins/request_elapsed_time_ms/availability", Severity: "error",
+				Code: "invalid-regex", Option: "good_http_status_regex",
+				Message: "option 'good_http_status_regex' with value '([xyz': invalid regex: " +
+					"error parsing regexp: missing closing ]: `[xyz`"}},
+		},
+		"Documents that are not mappings should be skipped.": {
+			content: "- a\n- list\n---\njust a scalar\n",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, test.exp, lint.File("spec.yml", []byte(test.content)))
+		})
+	}
+}
+
+func TestWrite(t *testing.T) {
+	asserts := assert.New(t)
+	results := lint.File("spec.yml", []byte(invalidSpec))
+	asserts.True(lint.HasErrors(results, false))
+
+	var text bytes.Buffer
+	asserts.NoError(lint.WriteText(&text, results[:1]))
+	asserts.Equal("spec.yml:12: error: [invalid-value] slo 'latency': option 'latency' with value 'fast': "+
+		"invalid value: needs to be a number between the lowest bucket 5 and the highest bucket 500000\n", text.String())
+
+	var jsonOutput bytes.Buffer
+	asserts.NoError(lint.WriteJSON(&jsonOutput, results))
+	var decoded []lint.Result
+	asserts.NoError(json.Unmarshal(jsonOutput.Bytes(), &decoded))
+	asserts.Equal(results, decoded)
+
+	var sarif bytes.Buffer
+	asserts.NoError(lint.WriteSARIF(&sarif, results))
+	var log struct {
+		Version string `json:"version"`
+		Runs    []struct {
+			Results []struct {
+				RuleID    string `json:"ruleId"`
+				Level     string `json:"level"`
+				Locations []struct {
+					PhysicalLocation struct {
+						Region struct {
+							StartLine int `json:"startLine"`
+						} `json:"region"`
+					} `json:"physicalLocation"`
+				} `json:"locations"`
+			} `json:"results"`
+		} `json:"runs"`
+	}
+	asserts.NoError(json.Unmarshal(sarif.Bytes(), &log))
+	asserts.Equal("2.1.0", log.Version)
+	if asserts.Len(log.Runs, 1) && asserts.Len(log.Runs[0].Results, 2) {
+		asserts.Equal("invalid-value", log.Runs[0].Results[0].RuleID)
+		asserts.Equal("error", log.Runs[0].Results[0].Level)
+		asserts.Equal(12, log.Runs[0].Results[0].Locations[0].PhysicalLocation.Region.StartLine)
+	}
+}
@@ -0,0 +1,112 @@
+// Package promqltest parses the label matchers of the selectors of rendered queries for tests,
+// following the PromQL grammar independently of the matcher parser and escaping of the plugins.
+package promqltest
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Matcher is a label matcher of a selector with its unquoted value.
+type Matcher struct {
+	Name  string
+	Op    string
+	Value string
+}
+
+// String returns the matcher in PromQL syntax.
+func (m Matcher) String() string {
+	return m.Name + m.Op + strconv.Quote(m.Value)
+}
+
+var labelName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*`)
+
+// ParseSelectors returns the label matchers of every selector of the query in order.
+// Range durations, e.g. `[{{.window}}]`, are skipped, other `{` outside of strings start a selector.
+// Values are unquoted like the Prometheus lexer does, with the escapes of Go string literals.
+func ParseSelectors(query string) ([][]Matcher, error) {
+	var selectors [][]Matcher
+	for i := 0; i < len(query); i++ {
+		switch query[i] {
+		case '[':
+			end := strings.IndexByte(query[i:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated range at %d", i)
+			}
+			i += end
+		case '{':
+			matchers, end, err := parseSelector(query, i+1)
+			if err != nil {
+				return nil, err
+			}
+			selectors = append(selectors, matchers)
+			i = end
+		}
+	}
+	return selectors, nil
+}
+
+// parseSelector parses the matchers of a selector starting after its `{`, returning the position of its `}`.
+func parseSelector(query string, i int) ([]Matcher, int, error) {
+	var matchers []Matcher
+	for {
+		i = skipSpaces(query, i)
+		if i < len(query) && query[i] == '}' {
+			return matchers, i, nil
+		}
+
+		name := labelName.FindString(query[i:])
+		if name == "" {
+			return nil, 0, fmt.Errorf("expected a label name at %d", i)
+		}
+		i = skipSpaces(query, i+len(name))
+
+		var op string
+		for _, candidate := range []string{"!=", "=~", "!~", "="} {
+			if strings.HasPrefix(query[i:], candidate) {
+				op = candidate
+				break
+			}
+		}
+		if op == "" {
+			return nil, 0, fmt.Errorf("expected a matcher operator at %d", i)
+		}
+		i = skipSpaces(query, i+len(op))
+
+		if i >= len(query) || query[i] != '"' {
+			return nil, 0, fmt.Errorf("expected a double-quoted string at %d", i)
+		}
+		end := i + 1
+		for ; end < len(query) && query[end] != '"'; end++ {
+			if query[end] == '\\' {
+				end++
+			}
+		}
+		if end >= len(query) {
+			return nil, 0, fmt.Errorf("unterminated string at %d", i)
+		}
+		value, err := strconv.Unquote(query[i : end+1])
+		if err != nil {
+			return nil, 0, fmt.Errorf("invalid string %s at %d: %w", query[i:end+1], i, err)
+		}
+		matchers = append(matchers, Matcher{Name: name, Op: op, Value: value})
+
+		i = skipSpaces(query, end+1)
+		switch {
+		case i < len(query) && query[i] == ',':
+			i++
+		case i < len(query) && query[i] == '}':
+		default:
+			return nil, 0, fmt.Errorf("expected ',' or '}' at %d", i)
+		}
+	}
+}
+
+func skipSpaces(query string, i int) int {
+	for i < len(query) && strings.ContainsRune(" \t\n", rune(query[i])) {
+		i++
+	}
+	return i
+}
@@ -0,0 +1,55 @@
+package promqltest_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/viatorinc/sloth-common-metric-plugins/internal/promqltest"
+)
+
+func TestParseSelectors(t *testing.T) {
+	tests := map[string]struct {
+		query  string
+		exp    [][]promqltest.Matcher
+		expErr bool
+	}{
+		"Every selector should be parsed with unquoted values.": {
+			query: `sum(rate(m{job="a", TX=~"/p\\.json"}[{{.window}}])) / sum(rate(m{job!="b", TX!~"\"}"}[5m]))`,
+			exp: [][]promqltest.Matcher{
+				{{Name: "job", Op: "=", Value: "a"}, {Name: "TX", Op: "=~", Value: `/p\.json`}},
+				{{Name: "job", Op: "!=", Value: "b"}, {Name: "TX", Op: "!~", Value: `"}`}},
+			},
+		},
+		"A trailing comma should be allowed.": {
+			query: `m{job="a",}`,
+			exp:   [][]promqltest.Matcher{{{Name: "job", Op: "=", Value: "a"}}},
+		},
+		"An unescaped quote should fail.": {
+			query:  `m{job="a"", x="b"}`,
+			expErr: true,
+		},
+		"An invalid escape should fail.": {
+			query:  `m{job="\q"}`,
+			expErr: true,
+		},
+		"A missing operator should fail.": {
+			query:  `m{job "a"}`,
+			expErr: true,
+		},
+		"An unterminated string should fail.": {
+			query:  `m{job="a}`,
+			expErr: true,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			selectors, err := promqltest.ParseSelectors(test.query)
+			if test.expErr {
+				assert.Error(t, err)
+			} else if assert.NoError(t, err) {
+				assert.Equal(t, test.exp, selectors)
+			}
+		})
+	}
+}
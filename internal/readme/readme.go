@@ -0,0 +1,155 @@
+// Package readme generates the "Options" and "Usage examples" sections of the plugin READMEs
+// from the option schema and the usage examples of the plugins.
+package readme
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/viatorinc/sloth-common-metric-plugins/internal/registry"
+)
+
+// Section is a generated part of a README, enclosed in begin and end markers.
+type Section struct {
+	Name     string
+	Generate func(plugin registry.Plugin) (string, error)
+}
+
+// Sections are the generated parts of the plugin READMEs.
+var Sections = []Section{
+	{Name: "options", Generate: OptionsTable},
+	{Name: "examples", Generate: UsageExamples},
+}
+
+// BeginMarker returns the marker starting a generated section.
+func BeginMarker(name string) string {
+	return fmt.Sprintf("<!-- BEGIN GENERATED %s: run `go run ./cmd/slothplug readme` to update -->", strings.ToUpper(name))
+}
+
+// EndMarker returns the marker ending a generated section.
+func EndMarker(name string) string {
+	return fmt.Sprintf("<!-- END GENERATED %s -->", strings.ToUpper(name))
+}
+
+// Update returns the README with all generated sections rewritten for the plugin.
+func Update(readme string, plugin registry.Plugin) (string, error) {
+	for _, section := range Sections {
+		begin, end := BeginMarker(section.Name), EndMarker(section.Name)
+		start := strings.Index(readme, begin)
+		stop := strings.Index(readme, end)
+		if start < 0 || stop < start {
+			return "", fmt.Errorf("missing markers of the generated %s section of %s", section.Name, plugin.ID)
+		}
+
+		generated, err := section.Generate(plugin)
+		if err != nil {
+			return "", fmt.Errorf("could not generate the %s section of %s: %w", section.Name, plugin.ID, err)
+		}
+		readme = readme[:start+len(begin)] + "\n\n" + generated + "\n" + readme[stop:]
+	}
+	return readme, nil
+}
+
+// OptionsTable returns a markdown table of the plugin options.
+func OptionsTable(plugin registry.Plugin) (string, error) {
+	var b strings.Builder
+	b.WriteString("| Option | Type | Mandatory | Default | Description | Example |\n")
+	b.WriteString("|--------|------|-----------|---------|-------------|---------|\n")
+	for _, option := range plugin.Options {
+		mandatory := "no"
+		if option.Mandatory {
+			mandatory = "**yes**"
+		}
+
+		defaultValue := "unset"
+		if option.Default != "" {
+			defaultValue = code(option.Default)
+		}
+
+		description := option.Description
+		if len(option.AllowedValues) > 0 {
+			var allowed []string
+			for _, value := range option.AllowedValues {
+				allowed = append(allowed, code(value))
+			}
+			description += ", one of " + strings.Join(allowed, ", ")
+		}
+		if option.Maximum != 0 {
+			description += fmt.Sprintf(", between %d and %d", option.Minimum, option.Maximum)
+		}
+		if option.Pattern != "" {
+			description += ", matching " + code(option.Pattern)
+		}
+
+		fmt.Fprintf(&b, "| %s | %s | %s | %s | %s | %s |\n", code(option.Name), option.Type, mandatory,
+			escapeCell(defaultValue), escapeCell(description), escapeCell(code(option.Example)))
+	}
+	return b.String(), nil
+}
+
+// UsageExamples returns the usage examples of the plugin with their spec and rendered query.
+func UsageExamples(plugin registry.Plugin) (string, error) {
+	var sections []string
+	for _, example := range plugin.Examples {
+		query, err := plugin.Render(nil, nil, example.Options)
+		if err != nil {
+			return "", fmt.Errorf("could not render example '%s': %w", example.Title, err)
+		}
+
+		var b strings.Builder
+		fmt.Fprintf(&b, "### %s\n\n", example.Title)
+		if example.Description != "" {
+			fmt.Fprintf(&b, "%s\n\n", example.Description)
+		}
+		b.WriteString("```yaml\nsli:\n  plugin:\n")
+		fmt.Fprintf(&b, "    id: %s\n    options:\n", yamlString(plugin.ID))
+		for _, name := range exampleOptionNames(plugin, example) {
+			fmt.Fprintf(&b, "      %s: %s\n", name, yamlString(example.Options[name]))
+		}
+		b.WriteString("```\n\nrenders the query:\n\n```promql")
+		b.WriteString(query)
+		b.WriteString("```\n")
+		sections = append(sections, b.String())
+	}
+	return strings.Join(sections, "\n"), nil
+}
+
+// exampleOptionNames returns the option names of the example in the order of the plugin options.
+func exampleOptionNames(plugin registry.Plugin, example registry.Example) []string {
+	order := map[string]int{}
+	for i, option := range plugin.Options {
+		order[option.Name] = i + 1
+	}
+
+	var names []string
+	for name := range example.Options {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if order[names[i]] != order[names[j]] {
+			return order[names[i]] < order[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	return names
+}
+
+// yamlString returns the value as a quoted YAML string, single-quoted when it contains double quotes.
+func yamlString(value string) string {
+	if strings.Contains(value, `"`) {
+		return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+	}
+	return strconv.Quote(value)
+}
+
+// code returns the value as inline markdown code.
+func code(value string) string {
+	return "`" + value + "`"
+}
+
+// escapeCell escapes the pipes of a markdown table cell.
+func escapeCell(value string) string {
+	return strings.ReplaceAll(value, "|", `\|`)
+}
@@ -0,0 +1,61 @@
+package readme_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/viatorinc/sloth-common-metric-plugins/internal/readme"
+	"github.com/viatorinc/sloth-common-metric-plugins/internal/registry"
+)
+
+func TestREADMEsUpToDate(t *testing.T) {
+	for _, plugin := range registry.Plugins() {
+		t.Run(plugin.ID, func(t *testing.T) {
+			content, err := os.ReadFile(filepath.Join("..", "..", plugin.Dir, "README.md"))
+			if assert.NoError(t, err) {
+				updated, err := readme.Update(string(content), plugin)
+				assert.NoError(t, err)
+				assert.Equal(t, string(content), updated, "run `go run ./cmd/slothplug readme` to update the README")
+			}
+		})
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	plugin, _ := registry.Lookup(registry.IDs()[0])
+
+	tests := map[string]struct {
+		readme string
+		expErr bool
+	}{
+		"Generated sections should be rewritten.": {
+			readme: "# Plugin\n" + readme.BeginMarker("options") + "\nstale\n" + readme.EndMarker("options") + "\n" +
+				readme.BeginMarker("examples") + readme.EndMarker("examples") + "\n",
+		},
+		"Missing markers should fail.": {
+			readme: "# Plugin\n" + readme.BeginMarker("options") + "\n" + readme.EndMarker("options") + "\n",
+			expErr: true,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			updated, err := readme.Update(test.readme, plugin)
+			if test.expErr {
+				assert.Error(t, err)
+				return
+			}
+			if assert.NoError(t, err) {
+				assert.NotContains(t, updated, "stale")
+				assert.Contains(t, updated, "| `servicename` | string | no |")
+				assert.Contains(t, updated, "```promql\n# plugin: "+plugin.ID+"\n")
+
+				again, err := readme.Update(updated, plugin)
+				assert.NoError(t, err)
+				assert.Equal(t, updated, again)
+			}
+		})
+	}
+}
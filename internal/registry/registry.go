@@ -0,0 +1,205 @@
+// Package registry lists the plugins of this repository for the tooling under ./cmd.
+//
+// The plugins can not share Go types, as each plugin is a single sloth plugin file,
+// so their option schemas are converted into the plugin independent types of this package.
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/viatorinc/sloth-common-metric-plugins/plugins/request_elapsed_time_ms/availability"
+	"github.com/viatorinc/sloth-common-metric-plugins/plugins/request_elapsed_time_ms/latency"
+)
+
+// Option is the plugin independent description of an option, see the OptionSpec of the plugins.
+type Option struct {
+	Name          string
+	Type          string
+	Mandatory     bool
+	Default       string
+	AllowedValues []string
+	Pattern       string
+	Minimum       int
+	Maximum       int
+	Description   string
+	Example       string
+}
+
+// Example is a documented example of the plugin options, see the UsageExample of the plugins.
+type Example struct {
+	Title       string
+	Description string
+	Options     map[string]string
+}
+
+// Plugin describes a plugin of this repository.
+// Like sloth, the functions take the metadata of the SLO and the labels of the spec besides the options,
+// both can be nil.
+type Plugin struct {
+	ID string
+	// Dir is the folder of the plugin, relative to the repository root.
+	Dir      string
+	Options  []Option
+	Examples []Example
+	// Render returns the query of the plugin for the options.
+	Render func(meta map[string]string, labels map[string]string, options map[string]string) (string, error)
+	// Check returns the validation errors and the advisories of the options.
+	Check func(meta map[string]string, labels map[string]string, options map[string]string) []Finding
+	// Explain returns a human-readable explanation of the SLI of the options.
+	Explain func(meta map[string]string, labels map[string]string, options map[string]string) (string, error)
+}
+
+// Severities of the findings.
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// Finding is a validation error or an advisory of plugin options.
+// Option is empty when the finding does not concern a single option.
+type Finding struct {
+	Severity string
+	Code     string
+	Option   string
+	Message  string
+}
+
+// errorCode returns the code of a validation error, derived from the message of its sentinel error.
+func errorCode(err error) string {
+	return strings.ReplaceAll(err.Error(), " ", "-")
+}
+
+// optionMessage returns the message prefixed with the option, like the validation errors of the plugins.
+func optionMessage(option string, message string) string {
+	if option == "" {
+		return message
+	}
+	return fmt.Sprintf("option '%s': %s", option, message)
+}
+
+// Plugins returns all plugins of this repository.
+func Plugins() []Plugin {
+	return []Plugin{availabilityPlugin(), latencyPlugin()}
+}
+
+// Lookup returns the plugin with the ID.
+func Lookup(id string) (Plugin, bool) {
+	for _, plugin := range Plugins() {
+		if plugin.ID == id {
+			return plugin, true
+		}
+	}
+	return Plugin{}, false
+}
+
+// IDs returns the IDs of all plugins of this repository.
+func IDs() []string {
+	var ids []string
+	for _, plugin := range Plugins() {
+		ids = append(ids, plugin.ID)
+	}
+	return ids
+}
+
+func availabilityPlugin() Plugin {
+	plugin := Plugin{
+		ID:  availability.SLIPluginID,
+		Dir: "plugins/request_elapsed_time_ms/availability",
+		Render: func(meta map[string]string, labels map[string]string, options map[string]string) (string, error) {
+			return availability.SLIPlugin(context.Background(), meta, labels, options)
+		},
+		Check: func(meta map[string]string, labels map[string]string, options map[string]string) []Finding {
+			completed, err := availability.ApplySLODefaults(meta, labels, options)
+			findings := availabilityErrorFindings(err)
+			_, err = availability.ValidateOptions(completed)
+			findings = append(findings, availabilityErrorFindings(err)...)
+			for _, advisory := range availability.Advise(completed) {
+				findings = append(findings, Finding{Severity: SeverityWarning, Code: advisory.Code,
+					Option: advisory.Option, Message: optionMessage(advisory.Option, advisory.Message)})
+			}
+			return findings
+		},
+		Explain: func(meta map[string]string, labels map[string]string, options map[string]string) (string, error) {
+			completed, err := availability.ApplySLODefaults(meta, labels, options)
+			if err != nil {
+				return "", err
+			}
+			return availability.Explain(completed)
+		},
+	}
+	for _, spec := range availability.OptionSchema {
+		plugin.Options = append(plugin.Options, Option{Name: spec.Name, Type: string(spec.Type),
+			Mandatory: spec.Mandatory, Default: spec.Default, AllowedValues: spec.AllowedValues, Pattern: spec.Pattern,
+			Minimum: spec.Minimum, Maximum: spec.Maximum, Description: spec.Description, Example: spec.Example})
+	}
+	for _, example := range availability.UsageExamples {
+		plugin.Examples = append(plugin.Examples, Example(example))
+	}
+	return plugin
+}
+
+func latencyPlugin() Plugin {
+	plugin := Plugin{
+		ID:  latency.SLIPluginID,
+		Dir: "plugins/request_elapsed_time_ms/latency",
+		Render: func(meta map[string]string, labels map[string]string, options map[string]string) (string, error) {
+			return latency.SLIPlugin(context.Background(), meta, labels, options)
+		},
+		Check: func(meta map[string]string, labels map[string]string, options map[string]string) []Finding {
+			completed, err := latency.ApplySLODefaults(meta, labels, options)
+			findings := latencyErrorFindings(err)
+			_, err = latency.ValidateOptions(completed)
+			findings = append(findings, latencyErrorFindings(err)...)
+			for _, advisory := range latency.Advise(completed) {
+				findings = append(findings, Finding{Severity: SeverityWarning, Code: advisory.Code,
+					Option: advisory.Option, Message: optionMessage(advisory.Option, advisory.Message)})
+			}
+			return findings
+		},
+		Explain: func(meta map[string]string, labels map[string]string, options map[string]string) (string, error) {
+			completed, err := latency.ApplySLODefaults(meta, labels, options)
+			if err != nil {
+				return "", err
+			}
+			return latency.Explain(completed)
+		},
+	}
+	for _, spec := range latency.OptionSchema {
+		plugin.Options = append(plugin.Options, Option{Name: spec.Name, Type: string(spec.Type),
+			Mandatory: spec.Mandatory, Default: spec.Default, AllowedValues: spec.AllowedValues, Pattern: spec.Pattern,
+			Minimum: spec.Minimum, Maximum: spec.Maximum, Description: spec.Description, Example: spec.Example})
+	}
+	for _, example := range latency.UsageExamples {
+		plugin.Examples = append(plugin.Examples, Example(example))
+	}
+	return plugin
+}
+
+// availabilityErrorFindings returns the findings of the ValidationErrors of the availability plugin.
+func availabilityErrorFindings(err error) []Finding {
+	var findings []Finding
+	var validationErrors availability.ValidationErrors
+	if errors.As(err, &validationErrors) {
+		for _, optionError := range validationErrors {
+			findings = append(findings, Finding{Severity: SeverityError, Code: errorCode(optionError.Err),
+				Option: optionError.Option, Message: optionError.Error()})
+		}
+	}
+	return findings
+}
+
+// latencyErrorFindings returns the findings of the ValidationErrors of the latency plugin.
+func latencyErrorFindings(err error) []Finding {
+	var findings []Finding
+	var validationErrors latency.ValidationErrors
+	if errors.As(err, &validationErrors) {
+		for _, optionError := range validationErrors {
+			findings = append(findings, Finding{Severity: SeverityError, Code: errorCode(optionError.Err),
+				Option: optionError.Option, Message: optionError.Error()})
+		}
+	}
+	return findings
+}
@@ -0,0 +1,179 @@
+// Package spec reads sloth prometheus/v1 SLO specs, keeping the line numbers of the SLOs and their plugin options.
+package spec
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Version is the sloth spec version supported by the plugins.
+const Version = "prometheus/v1"
+
+// Spec is a sloth SLO spec.
+type Spec struct {
+	Version string
+	Service string
+	Labels  map[string]string
+	SLOs    []SLO
+	// Line is the line of the version, 0 if it is missing.
+	Line int
+}
+
+// SLO is an SLO of a spec, reduced to its SLI plugin.
+// PluginID is empty for SLOs using raw or events SLIs.
+// Labels are the labels of the spec, sloth passes them to the plugin instead of the labels of the SLO.
+type SLO struct {
+	Name      string
+	Objective string
+	Labels    map[string]string
+	PluginID  string
+	Options   map[string]string
+	// Line is the line of the SLO, OptionLines are the lines of the plugin options.
+	Line        int
+	OptionLines map[string]int
+}
+
+// FindSLO returns the SLO with the name.
+func (s Spec) FindSLO(name string) (SLO, bool) {
+	for _, slo := range s.SLOs {
+		if slo.Name == name {
+			return slo, true
+		}
+	}
+	return SLO{}, false
+}
+
+// Meta returns the metadata sloth passes to the plugin of the SLO.
+func (s Spec) Meta(slo SLO) map[string]string {
+	return map[string]string{"service": s.Service, "slo": slo.Name, "objective": slo.Objective}
+}
+
+// Parse returns the spec of a YAML document.
+func Parse(content []byte) (Spec, error) {
+	var document yaml.Node
+	if err := yaml.Unmarshal(content, &document); err != nil {
+		return Spec{}, err
+	}
+	if len(document.Content) == 0 {
+		return Spec{}, fmt.Errorf("empty spec")
+	}
+	return parseDocument(document.Content[0])
+}
+
+// ParseAll returns the prometheus/v1 specs of the documents of a YAML stream, e.g. a file with `---` separated specs.
+// Other documents, e.g. other Kubernetes resources or YAML files, are skipped.
+func ParseAll(content []byte) ([]Spec, error) {
+	var specs []Spec
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	for {
+		var document yaml.Node
+		err := decoder.Decode(&document)
+		if errors.Is(err, io.EOF) {
+			return specs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(document.Content) == 0 {
+			continue
+		}
+		if version := child(document.Content[0], "version"); version == nil || version.Value != Version {
+			continue
+		}
+
+		spec, err := parseDocument(document.Content[0])
+		if err != nil {
+			return nil, err
+		}
+		specs = append(specs, spec)
+	}
+}
+
+// FindSLO returns the SLO with the name and its spec.
+func FindSLO(specs []Spec, name string) (Spec, SLO, bool) {
+	for _, spec := range specs {
+		if slo, ok := spec.FindSLO(name); ok {
+			return spec, slo, true
+		}
+	}
+	return Spec{}, SLO{}, false
+}
+
+// parseDocument returns the spec of the root node of a YAML document.
+func parseDocument(root *yaml.Node) (Spec, error) {
+	if root.Kind != yaml.MappingNode {
+		return Spec{}, fmt.Errorf("line %d: expected a mapping", root.Line)
+	}
+
+	var spec Spec
+	if version := child(root, "version"); version != nil {
+		spec.Version, spec.Line = version.Value, version.Line
+	}
+	if service := child(root, "service"); service != nil {
+		spec.Service = service.Value
+	}
+	spec.Labels = stringMap(child(root, "labels"))
+
+	slos := child(root, "slos")
+	if slos == nil {
+		return spec, nil
+	}
+	if slos.Kind != yaml.SequenceNode {
+		return Spec{}, fmt.Errorf("line %d: expected slos to be a list", slos.Line)
+	}
+
+	for _, node := range slos.Content {
+		slo := SLO{Line: node.Line, Labels: map[string]string{}, Options: map[string]string{}, OptionLines: map[string]int{}}
+		if name := child(node, "name"); name != nil {
+			slo.Name = name.Value
+		}
+		if objective := child(node, "objective"); objective != nil {
+			slo.Objective = objective.Value
+		}
+		for key, value := range spec.Labels {
+			slo.Labels[key] = value
+		}
+		plugin := child(child(node, "sli"), "plugin")
+		if id := child(plugin, "id"); id != nil {
+			slo.PluginID = id.Value
+		}
+		if options := child(plugin, "options"); options != nil && options.Kind == yaml.MappingNode {
+			for i := 0; i+1 < len(options.Content); i += 2 {
+				key, value := options.Content[i], options.Content[i+1]
+				slo.Options[key.Value] = value.Value
+				slo.OptionLines[key.Value] = key.Line
+			}
+		}
+		spec.SLOs = append(spec.SLOs, slo)
+	}
+	return spec, nil
+}
+
+// stringMap returns the scalar values of a mapping node, empty if the node is not a mapping.
+func stringMap(node *yaml.Node) map[string]string {
+	values := map[string]string{}
+	if node == nil || node.Kind != yaml.MappingNode {
+		return values
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		values[node.Content[i].Value] = node.Content[i+1].Value
+	}
+	return values
+}
+
+// child returns the value of a key of a mapping node, nil if the node is not a mapping or does not have the key.
+func child(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package spec_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/viatorinc/sloth-common-metric-plugins/internal/spec"
+)
+
+func TestParse(t *testing.T) {
+	tests := map[string]struct {
+		content string
+		exp     spec.Spec
+		expErr  bool
+	}{
+		"SLOs should be parsed with their lines and the labels of the spec.": {
+			content: `version: "prometheus/v1"
+service: "demandproduct"
+labels:
+  env: "prod"
+  team: "demand"
+slos:
+  - name: "availability"
+    objective: 99.9
+    labels:
+      team: "product"
+    sli:
+      plugin:
+        id: "viator-sloth-plugins/request_elapsed_time_ms/latency"
+        options:
+          servicename: "demandproduct"
+          latency: 250
+  - name: "raw"
+    sli:
+      raw:
+        error_ratio_query: "0"
+`,
+			exp: spec.Spec{Version: "prometheus/v1", Service: "demandproduct", Line: 1,
+				Labels: map[string]string{"env": "prod", "team": "demand"}, SLOs: []spec.SLO{
+					{Name: "availability", Objective: "99.9", Labels: map[string]string{"env": "prod", "team": "demand"},
+						PluginID: "viator-sloth-plugins/request_elapsed_time_ms/latency", Line: 7,
+						Options:     map[string]string{"servicename": "demandproduct", "latency": "250"},
+						OptionLines: map[string]int{"servicename": 15, "latency": 16}},
+					{Name: "raw", Labels: map[string]string{"env": "prod", "team": "demand"}, Line: 17,
+						Options: map[string]string{}, OptionLines: map[string]int{}},
+				}},
+		},
+		"Invalid YAML should fail.": {
+			content: "slos: [",
+			expErr:  true,
+		},
+		"SLOs that are not a list should fail.": {
+			content: "slos: 1",
+			expErr:  true,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			parsed, err := spec.Parse([]byte(test.content))
+			if test.expErr {
+				assert.Error(t, err)
+			} else if assert.NoError(t, err) {
+				assert.Equal(t, test.exp, parsed)
+			}
+		})
+	}
+}
+
+func TestParseAll(t *testing.T) {
+	tests := map[string]struct {
+		content string
+		exp     []string
+		expErr  bool
+	}{
+		"Every spec of the documents should be parsed.": {
+			content: "version: \"prometheus/v1\"\nservice: \"first\"\n---\n" +
+				"version: \"prometheus/v1\"\nservice: \"second\"\n",
+			exp: []string{"first", "second"},
+		},
+		"Documents that are not specs should be skipped.": {
+			content: "apiVersion: v1\nkind: ConfigMap\n---\n- a list\n---\nscalar\n---\n" +
+				"version: \"prometheus/v1\"\nservice: \"demandproduct\"\n",
+			exp: []string{"demandproduct"},
+		},
+		"An empty stream should not have specs.": {
+			content: "",
+		},
+		"Invalid YAML should fail.": {
+			content: "version: \"prometheus/v1\"\n---\nslos: [",
+			expErr:  true,
+		},
+		"A spec with SLOs that are not a list should fail.": {
+			content: "version: \"prometheus/v1\"\nslos: 1\n",
+			expErr:  true,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			specs, err := spec.ParseAll([]byte(test.content))
+			if test.expErr {
+				assert.Error(t, err)
+				return
+			}
+			if assert.NoError(t, err) {
+				var services []string
+				for _, parsed := range specs {
+					services = append(services, parsed.Service)
+				}
+				assert.Equal(t, test.exp, services)
+			}
+		})
+	}
+}
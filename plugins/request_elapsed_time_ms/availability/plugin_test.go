@@ -2,10 +2,17 @@ package availability_test
 
 import (
 	"context"
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/viatorinc/sloth-common-metric-plugins/internal/promqltest"
 	"github.com/viatorinc/sloth-common-metric-plugins/plugins/request_elapsed_time_ms/availability"
 )
 
@@ -32,9 +39,9 @@ func TestSLIPlugin(t *testing.T) {
 			options: map[string]string{"servicename": "demandproduct"},
 			expQuery: `
 1 - ((
-	sum(rate(request:ELAPSED_TIME_MS_count{job="demandproduct-metrics", RESPONSE_STATUS=~"2.."}[{{.window}}]))
+	sum(rate(request:ELAPSED_TIME_MS_count{job="demandproduct-metrics", APM_TRANSACTION!~"/ping|/health|/healthcheck|/healthz|/ready|/readiness|/readyz|/live|/liveness|/livez|/actuator/health(/.*)?", RESPONSE_STATUS=~"2.."}[{{.window}}]))
 	/
-	(sum(rate(request:ELAPSED_TIME_MS_count{job="demandproduct-metrics"}[{{.window}}])) > 0)
+	(sum(rate(request:ELAPSED_TIME_MS_count{job="demandproduct-metrics", APM_TRANSACTION!~"/ping|/health|/healthcheck|/healthz|/ready|/readiness|/readyz|/live|/liveness|/livez|/actuator/health(/.*)?"}[{{.window}}])) > 0)
 ) OR on() vector(1))
 `,
 		},
@@ -42,20 +49,318 @@ func TestSLIPlugin(t *testing.T) {
 			options: map[string]string{
 				"servicename":            "demandproduct",
 				"apm_tx":                 "someapm",
-				"apm_tx_regex":           ".*apm.*",
 				"filter":                 `r="v",s="w"`,
 				"success_filter":         `o="g",p="h"`,
 				"good_http_status_regex": `[2-4]..`,
-				"bad_http_status_regex":  `[404|302]`,
+				"bad_http_status_regex":  `(404|302)`,
+			},
+			expQuery: `
+1 - ((
+	sum(rate(request:ELAPSED_TIME_MS_count{job="demandproduct-metrics", APM_TRANSACTION="someapm", r="v", s="w", RESPONSE_STATUS=~"[2-4]..", RESPONSE_STATUS!~"(404|302)", o="g", p="h"}[{{.window}}]))
+	/
+	(sum(rate(request:ELAPSED_TIME_MS_count{job="demandproduct-metrics", APM_TRANSACTION="someapm", r="v", s="w"}[{{.window}}])) > 0)
+) OR on() vector(1))
+`,
+		},
+		"Excluded requests should be removed from total and success queries.": {
+			options: map[string]string{
+				"servicename":                          "demandproduct",
+				"apm_tx":                               "/product/full",
+				"exclude_from_total_http_status_regex": "4..",
+				"exclude_filter":                       `CLIENT="bot", REGION=~"test.*"`,
+			},
+			expQuery: `
+1 - ((
+	sum(rate(request:ELAPSED_TIME_MS_count{job="demandproduct-metrics", APM_TRANSACTION="/product/full", RESPONSE_STATUS!~"4..", CLIENT!="bot", REGION!~"test.*", RESPONSE_STATUS=~"2.."}[{{.window}}]))
+	/
+	(sum(rate(request:ELAPSED_TIME_MS_count{job="demandproduct-metrics", APM_TRANSACTION="/product/full", RESPONSE_STATUS!~"4..", CLIENT!="bot", REGION!~"test.*"}[{{.window}}])) > 0)
+) OR on() vector(1))
+`,
+		},
+		"An invalid exclude filter should fail.": {
+			options: map[string]string{"servicename": "demandproduct", "exclude_filter": `CLIENT`},
+			expErr:  true,
+		},
+		"An invalid exclude status regex should fail.": {
+			options: map[string]string{"servicename": "demandproduct", "exclude_from_total_http_status_regex": "([xyz"},
+			expErr:  true,
+		},
+		"Health checks should not be excluded when disabled.": {
+			options: map[string]string{"servicename": "demandproduct", "exclude_health_checks": "false"},
+			expQuery: `
+1 - ((
+	sum(rate(request:ELAPSED_TIME_MS_count{job="demandproduct-metrics", RESPONSE_STATUS=~"2.."}[{{.window}}]))
+	/
+	(sum(rate(request:ELAPSED_TIME_MS_count{job="demandproduct-metrics"}[{{.window}}])) > 0)
+) OR on() vector(1))
+`,
+		},
+		"Overridden health checks should be excluded.": {
+			options: map[string]string{
+				"servicename":               "demandproduct",
+				"apm_tx_regex":              "/product/.*",
+				"health_check_apm_tx_regex": "/product/ping",
+			},
+			expQuery: `
+1 - ((
+	sum(rate(request:ELAPSED_TIME_MS_count{job="demandproduct-metrics", APM_TRANSACTION=~"/product/.*", APM_TRANSACTION!~"/product/ping", RESPONSE_STATUS=~"2.."}[{{.window}}]))
+	/
+	(sum(rate(request:ELAPSED_TIME_MS_count{job="demandproduct-metrics", APM_TRANSACTION=~"/product/.*", APM_TRANSACTION!~"/product/ping"}[{{.window}}])) > 0)
+) OR on() vector(1))
+`,
+		},
+		"An invalid exclude_health_checks value should fail.": {
+			options: map[string]string{"servicename": "demandproduct", "exclude_health_checks": "maybe"},
+			expErr:  true,
+		},
+		"Excluded transactions should be rendered as negative matchers.": {
+			options: map[string]string{
+				"servicename":           "demandproduct",
+				"apm_tx_regex":          "/product/.*",
+				"apm_tx_exclude":        "/product/legacy",
+				"apm_tx_exclude_regex":  "/product/internal/.*",
+				"exclude_health_checks": "false",
+			},
+			expQuery: `
+1 - ((
+	sum(rate(request:ELAPSED_TIME_MS_count{job="demandproduct-metrics", APM_TRANSACTION=~"/product/.*", APM_TRANSACTION!="/product/legacy", APM_TRANSACTION!~"/product/internal/.*", RESPONSE_STATUS=~"2.."}[{{.window}}]))
+	/
+	(sum(rate(request:ELAPSED_TIME_MS_count{job="demandproduct-metrics", APM_TRANSACTION=~"/product/.*", APM_TRANSACTION!="/product/legacy", APM_TRANSACTION!~"/product/internal/.*"}[{{.window}}])) > 0)
+) OR on() vector(1))
+`,
+		},
+		"An invalid apm_tx_exclude_regex should fail.": {
+			options: map[string]string{"servicename": "demandproduct", "apm_tx_exclude_regex": "([xyz"},
+			expErr:  true,
+		},
+		"A list of transactions and globs should be rendered as an escaped regex.": {
+			options: map[string]string{
+				"servicename": "demandproduct",
+				"apm_tx":      "/product/full, /product.json",
+				"apm_tx_glob": "/product/*/reviews",
+			},
+			expQuery: `
+1 - ((
+	sum(rate(request:ELAPSED_TIME_MS_count{job="demandproduct-metrics", APM_TRANSACTION=~"/product/full|/product\\.json|/product/[^/]*/reviews", RESPONSE_STATUS=~"2.."}[{{.window}}]))
+	/
+	(sum(rate(request:ELAPSED_TIME_MS_count{job="demandproduct-metrics", APM_TRANSACTION=~"/product/full|/product\\.json|/product/[^/]*/reviews"}[{{.window}}])) > 0)
+) OR on() vector(1))
+`,
+		},
+		"Case-insensitive transactions should be rendered as case-insensitive regexes.": {
+			options: map[string]string{
+				"servicename":             "demandproduct",
+				"apm_tx":                  "/Product/Full",
+				"apm_tx_glob":             "/Product/*/Reviews",
+				"apm_tx_case_insensitive": "true",
+			},
+			expQuery: `
+1 - ((
+	sum(rate(request:ELAPSED_TIME_MS_count{job="demandproduct-metrics", APM_TRANSACTION=~"(?i)/Product/Full|/Product/[^/]*/Reviews", RESPONSE_STATUS=~"2.."}[{{.window}}]))
+	/
+	(sum(rate(request:ELAPSED_TIME_MS_count{job="demandproduct-metrics", APM_TRANSACTION=~"(?i)/Product/Full|/Product/[^/]*/Reviews"}[{{.window}}])) > 0)
+) OR on() vector(1))
+`,
+		},
+		"A case-insensitive transaction regex should be rendered with (?i).": {
+			options: map[string]string{
+				"servicename":             "demandproduct",
+				"apm_tx_regex":            "/product/.*",
+				"apm_tx_case_insensitive": "true",
+				"exclude_health_checks":   "false",
+			},
+			expQuery: `
+1 - ((
+	sum(rate(request:ELAPSED_TIME_MS_count{job="demandproduct-metrics", APM_TRANSACTION=~"(?i)/product/.*", RESPONSE_STATUS=~"2.."}[{{.window}}]))
+	/
+	(sum(rate(request:ELAPSED_TIME_MS_count{job="demandproduct-metrics", APM_TRANSACTION=~"(?i)/product/.*"}[{{.window}}])) > 0)
+) OR on() vector(1))
+`,
+		},
+		"An invalid apm_tx_case_insensitive value should fail.": {
+			options: map[string]string{"servicename": "demandproduct", "apm_tx": "/product", "apm_tx_case_insensitive": "yes please"},
+			expErr:  true,
+		},
+		"An invalid filter should fail.": {
+			options: map[string]string{"servicename": "demandproduct", "filter": `CLIENT=TRIPADVISOR`},
+			expErr:  true,
+		},
+		"Quotes and backslashes in values should be escaped.": {
+			options: map[string]string{
+				"servicename":          "demandproduct",
+				"apm_tx":               `/product"}`,
+				"apm_tx_exclude_regex": `/product\.json`,
+			},
+			expQuery: `
+1 - ((
+	sum(rate(request:ELAPSED_TIME_MS_count{job="demandproduct-metrics", APM_TRANSACTION="/product\"}", APM_TRANSACTION!~"/product\\.json", RESPONSE_STATUS=~"2.."}[{{.window}}]))
+	/
+	(sum(rate(request:ELAPSED_TIME_MS_count{job="demandproduct-metrics", APM_TRANSACTION="/product\"}", APM_TRANSACTION!~"/product\\.json"}[{{.window}}])) > 0)
+) OR on() vector(1))
+`,
+		},
+		"An invalid servicename should fail.": {
+			options: map[string]string{"servicename": `demandproduct-metrics", job="other`},
+			expErr:  true,
+		},
+		"An unknown option should fail.": {
+			options: map[string]string{"servicename": "demandproduct", "status_regex": "(2..|404)"},
+			expErr:  true,
+		},
+		"Conflicting transaction options should fail.": {
+			options: map[string]string{"servicename": "demandproduct", "apm_tx": "/product/full", "apm_tx_regex": "/product/.*"},
+			expErr:  true,
+		},
+		"Case-insensitive matching without transactions should fail.": {
+			options: map[string]string{"servicename": "demandproduct", "apm_tx_case_insensitive": "true"},
+			expErr:  true,
+		},
+		"The servicename should default to the service of the SLO and SLO labels should be added to the filter.": {
+			meta:    map[string]string{"service": "demandproduct", "slo": "availability"},
+			labels:  map[string]string{"env": "prod", "team": "demand"},
+			options: map[string]string{"apm_tx": "/product/full", "filter": `CLIENT="TRIPADVISOR"`, "filter_from_labels": "env"},
+			expQuery: `
+1 - ((
+	sum(rate(request:ELAPSED_TIME_MS_count{job="demandproduct-metrics", APM_TRANSACTION="/product/full", CLIENT="TRIPADVISOR", env="prod", RESPONSE_STATUS=~"2.."}[{{.window}}]))
+	/
+	(sum(rate(request:ELAPSED_TIME_MS_count{job="demandproduct-metrics", APM_TRANSACTION="/product/full", CLIENT="TRIPADVISOR", env="prod"}[{{.window}}])) > 0)
+) OR on() vector(1))
+`,
+		},
+		"A servicename option should take precedence over the service of the SLO.": {
+			meta:    map[string]string{"service": "demandproduct-slos"},
+			options: map[string]string{"servicename": "demandproduct", "apm_tx": "/product/full"},
+			expQuery: `
+1 - ((
+	sum(rate(request:ELAPSED_TIME_MS_count{job="demandproduct-metrics", APM_TRANSACTION="/product/full", RESPONSE_STATUS=~"2.."}[{{.window}}]))
+	/
+	(sum(rate(request:ELAPSED_TIME_MS_count{job="demandproduct-metrics", APM_TRANSACTION="/product/full"}[{{.window}}])) > 0)
+) OR on() vector(1))
+`,
+		},
+		"A label missing on the SLO should fail.": {
+			options: map[string]string{"servicename": "demandproduct", "filter_from_labels": "env"},
+			expErr:  true,
+		},
+		"An exact job should replace the job derived from the servicename.": {
+			options: map[string]string{"job": "demandproduct/demandproduct-pods", "apm_tx": "/product/full"},
+			expQuery: `
+1 - ((
+	sum(rate(request:ELAPSED_TIME_MS_count{job="demandproduct/demandproduct-pods", APM_TRANSACTION="/product/full", RESPONSE_STATUS=~"2.."}[{{.window}}]))
+	/
+	(sum(rate(request:ELAPSED_TIME_MS_count{job="demandproduct/demandproduct-pods", APM_TRANSACTION="/product/full"}[{{.window}}])) > 0)
+) OR on() vector(1))
+`,
+		},
+		"A job regex should replace the job derived from the servicename.": {
+			options: map[string]string{"servicename": "demandproduct", "job_regex": "demandproduct-(metrics|canary)",
+				"apm_tx": "/product/full"},
+			expQuery: `
+1 - ((
+	sum(rate(request:ELAPSED_TIME_MS_count{job=~"demandproduct-(metrics|canary)", APM_TRANSACTION="/product/full", RESPONSE_STATUS=~"2.."}[{{.window}}]))
+	/
+	(sum(rate(request:ELAPSED_TIME_MS_count{job=~"demandproduct-(metrics|canary)", APM_TRANSACTION="/product/full"}[{{.window}}])) > 0)
+) OR on() vector(1))
+`,
+		},
+		"A job suffix should be appended to the servicename.": {
+			options: map[string]string{"servicename": "demandproduct", "job_suffix": "-pods", "apm_tx": "/product/full"},
+			expQuery: `
+1 - ((
+	sum(rate(request:ELAPSED_TIME_MS_count{job="demandproduct-pods", APM_TRANSACTION="/product/full", RESPONSE_STATUS=~"2.."}[{{.window}}]))
+	/
+	(sum(rate(request:ELAPSED_TIME_MS_count{job="demandproduct-pods", APM_TRANSACTION="/product/full"}[{{.window}}])) > 0)
+) OR on() vector(1))
+`,
+		},
+		"A job together with a job regex should fail.": {
+			options: map[string]string{"job": "demandproduct-metrics", "job_regex": "demandproduct-.*"},
+			expErr:  true,
+		},
+		"A job suffix together with a job should fail.": {
+			options: map[string]string{"servicename": "demandproduct", "job": "demandproduct-metrics", "job_suffix": "-pods"},
+			expErr:  true,
+		},
+		"An invalid job suffix should fail.": {
+			options: map[string]string{"servicename": "demandproduct", "job_suffix": `", job="other`},
+			expErr:  true,
+		},
+		"The default metric profile should render the default query.": {
+			options: map[string]string{"servicename": "demandproduct", "apm_tx": "/product/full",
+				"metric_profile": "request_elapsed_time_ms"},
+			expQuery: `
+1 - ((
+	sum(rate(request:ELAPSED_TIME_MS_count{job="demandproduct-metrics", APM_TRANSACTION="/product/full", RESPONSE_STATUS=~"2.."}[{{.window}}]))
+	/
+	(sum(rate(request:ELAPSED_TIME_MS_count{job="demandproduct-metrics", APM_TRANSACTION="/product/full"}[{{.window}}])) > 0)
+) OR on() vector(1))
+`,
+		},
+		"An unknown metric profile should fail.": {
+			options: map[string]string{"servicename": "demandproduct", "metric_profile": "unknown"},
+			expErr:  true,
+		},
+		"Every exclude filter matcher should exclude the requests it matches on its own.": {
+			options: map[string]string{
+				"servicename":    "demandproduct",
+				"apm_tx":         "/product/full",
+				"exclude_filter": `CLIENT="bot", REGION="eu"`,
 			},
 			expQuery: `
 1 - ((
-	sum(rate(request:ELAPSED_TIME_MS_count{job="demandproduct-metrics", APM_TRANSACTION="someapm", APM_TRANSACTION=~".*apm.*", r="v", s="w", RESPONSE_STATUS=~"[2-4]..", RESPONSE_STATUS!~"[404|302]", o="g", p="h"}[{{.window}}]))
+	sum(rate(request:ELAPSED_TIME_MS_count{job="demandproduct-metrics", APM_TRANSACTION="/product/full", CLIENT!="bot", REGION!="eu", RESPONSE_STATUS=~"2.."}[{{.window}}]))
+	/
+	(sum(rate(request:ELAPSED_TIME_MS_count{job="demandproduct-metrics", APM_TRANSACTION="/product/full", CLIENT!="bot", REGION!="eu"}[{{.window}}])) > 0)
+) OR on() vector(1))
+`,
+		},
+		"SLO labels needing escaping should be appended to a braced filter.": {
+			meta:   map[string]string{"service": "demandproduct"},
+			labels: map[string]string{"team": `a"b\c`},
+			options: map[string]string{"apm_tx": "/product/full", "filter": `{CLIENT=~"TRIP.*"}`,
+				"filter_from_labels": "team"},
+			expQuery: `
+1 - ((
+	sum(rate(request:ELAPSED_TIME_MS_count{job="demandproduct-metrics", APM_TRANSACTION="/product/full", CLIENT=~"TRIP.*", team="a\"b\\c", RESPONSE_STATUS=~"2.."}[{{.window}}]))
+	/
+	(sum(rate(request:ELAPSED_TIME_MS_count{job="demandproduct-metrics", APM_TRANSACTION="/product/full", CLIENT=~"TRIP.*", team="a\"b\\c"}[{{.window}}])) > 0)
+) OR on() vector(1))
+`,
+		},
+		"The client metric profile should render the outbound client metric.": {
+			options: map[string]string{"servicename": "demandproduct", "apm_tx": "/product/full",
+				"metric_profile": "client_request_elapsed_time_ms"},
+			expQuery: `
+1 - ((
+	sum(rate(client_request:ELAPSED_TIME_MS_count{job="demandproduct-metrics", APM_TRANSACTION="/product/full", RESPONSE_STATUS=~"2.."}[{{.window}}]))
+	/
+	(sum(rate(client_request:ELAPSED_TIME_MS_count{job="demandproduct-metrics", APM_TRANSACTION="/product/full"}[{{.window}}])) > 0)
+) OR on() vector(1))
+`,
+		},
+		"The gRPC metric profile should render its labels, good status and health checks.": {
+			options: map[string]string{"servicename": "demandproduct", "metric_profile": "grpc_request_elapsed_time_ms"},
+			expQuery: `
+1 - ((
+	sum(rate(grpc_request:ELAPSED_TIME_MS_count{job="demandproduct-metrics", GRPC_METHOD!~"grpc.health.v1.Health/.*", GRPC_STATUS=~"OK"}[{{.window}}]))
 	/
-	(sum(rate(request:ELAPSED_TIME_MS_count{job="demandproduct-metrics", APM_TRANSACTION="someapm", APM_TRANSACTION=~".*apm.*", r="v", s="w"}[{{.window}}])) > 0)
+	(sum(rate(grpc_request:ELAPSED_TIME_MS_count{job="demandproduct-metrics", GRPC_METHOD!~"grpc.health.v1.Health/.*"}[{{.window}}])) > 0)
 ) OR on() vector(1))
 `,
 		},
+		"The gRPC metric profile should validate the status regexes against the gRPC status codes.": {
+			options: map[string]string{"servicename": "demandproduct", "metric_profile": "grpc_request_elapsed_time_ms",
+				"good_http_status_regex": "2.."},
+			expErr: true,
+		},
+		"A false looking transaction should conflict with a transaction regex.": {
+			options: map[string]string{"servicename": "demandproduct", "apm_tx": "F", "apm_tx_regex": "a.*"},
+			expErr:  true,
+		},
+		"A false looking job regex should conflict with a job.": {
+			options: map[string]string{"job": "demandproduct-metrics", "job_regex": "0"},
+			expErr:  true,
+		},
 	}
 
 	for name, test := range tests {
@@ -67,8 +372,984 @@ func TestSLIPlugin(t *testing.T) {
 			if test.expErr {
 				asserts.Error(err)
 			} else if asserts.NoError(err) {
-				asserts.Equal(strings.Trim(test.expQuery, " \n\t"), strings.Trim(gotQuery, " \n\t"))
+				asserts.Equal(strings.Trim(test.expQuery, " \n\t"), strings.Trim(withoutTraceHeader(gotQuery), " \n\t"))
+			}
+		})
+	}
+}
+
+func TestGlobToRegex(t *testing.T) {
+	tests := map[string]struct {
+		glob     string
+		matches  []string
+		excludes []string
+	}{
+		"A glob without wildcards should only match itself.": {
+			glob:     "/product.json",
+			matches:  []string{"/product.json"},
+			excludes: []string{"/product_json", "/product.json/full"},
+		},
+		"A single star should match within a path segment.": {
+			glob:     "/product/*",
+			matches:  []string{"/product/full", "/product/"},
+			excludes: []string{"/product/full/reviews", "/products/full"},
+		},
+		"A double star should match across path segments.": {
+			glob:     "/product/**",
+			matches:  []string{"/product/full", "/product/full/reviews"},
+			excludes: []string{"/products/full"},
+		},
+		"A question mark should match a single character.": {
+			glob:     "/v?/product",
+			matches:  []string{"/v1/product", "/v2/product"},
+			excludes: []string{"/v10/product", "/v/product"},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			asserts := assert.New(t)
+
+			// Prometheus fully anchors regex matchers.
+			regex := regexp.MustCompile("^(?:" + availability.GlobToRegex(test.glob) + ")$")
+
+			for _, value := range test.matches {
+				asserts.True(regex.MatchString(value), "expected %q to match %q", test.glob, value)
+			}
+			for _, value := range test.excludes {
+				asserts.False(regex.MatchString(value), "expected %q not to match %q", test.glob, value)
+			}
+		})
+	}
+}
+
+func TestParseMatchers(t *testing.T) {
+	tests := map[string]struct {
+		filter    string
+		expFilter string
+		expErr    string
+	}{
+		"An empty filter should return an empty filter.": {
+			filter:    " ",
+			expFilter: "",
+		},
+		"All operators should be formatted canonically.": {
+			filter:    `a = "x", b != "y",c=~ "z.*" , d !~"w"`,
+			expFilter: `a="x", b!="y", c=~"z.*", d!~"w"`,
+		},
+		"Commas and operators inside quotes should be kept.": {
+			filter:    `{a="x, y", b="k=v", c=~"1|2"}`,
+			expFilter: `a="x, y", b="k=v", c=~"1|2"`,
+		},
+		"Single quotes and backticks should be re-quoted.": {
+			filter:    "a='it\\'s \"x\"', b=`\\d+`",
+			expFilter: `a="it's \"x\"", b="\\d+"`,
+		},
+		"Superfluous commas and braces should be removed.": {
+			filter:    `, {k1="v1",, k2="v2",} ,`,
+			expFilter: `k1="v1", k2="v2"`,
+		},
+		"A missing operator should fail with its position.": {
+			filter: `a="x", b "y"`,
+			expErr: "invalid filter at position 10: expected label matching operator ('=', '!=', '=~' or '!~'), got '\"'",
+		},
+		"An unquoted value should fail with its position.": {
+			filter: `a=x`,
+			expErr: "invalid filter at position 3: expected quoted label value, got 'x'",
+		},
+		"An invalid label name should fail with its position.": {
+			filter: `a="x", 1b="y"`,
+			expErr: "invalid filter at position 8: expected label name, got '1'",
+		},
+		"An unterminated value should fail with its position.": {
+			filter: `a="x`,
+			expErr: "invalid filter at position 3: unterminated quoted label value",
+		},
+		"An invalid regex should fail with its position.": {
+			filter: `a=~"([xyz"`,
+			expErr: "invalid filter at position 4: invalid regex for label 'a': error parsing regexp: missing closing ]: `[xyz`",
+		},
+		"Missing separators should fail with their position.": {
+			filter: `a="x" b="y"`,
+			expErr: "invalid filter at position 7: expected ',' or '}' after label matcher, got 'b'",
+		},
+		"Unbalanced braces should fail.": {
+			filter: `{a="x"`,
+			expErr: "invalid filter at position 7: missing closing '}'",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			asserts := assert.New(t)
+
+			gotMatchers, err := availability.ParseMatchers(test.filter)
+
+			if test.expErr != "" {
+				asserts.EqualError(err, test.expErr)
+			} else if asserts.NoError(err) {
+				asserts.Equal(test.expFilter, availability.FormatMatchers(gotMatchers))
+			}
+		})
+	}
+}
+
+func FuzzRenderedSelector(f *testing.F) {
+	f.Add("demandproduct", "/product/full", "", "/product/legacy", `/product\.json`, `CLIENT="TRIPADVISOR"`, "2..")
+	f.Add("demandproduct", "/a, /b.json", "", `x", injected="y`, `.*"}`, `CLIENT!~"\\\\"`, "(2..|404)")
+	f.Add("demand.product", "", "/product/.*", `\"`, "`", `{job="other"}`, "")
+	f.Add("demandproduct", `/product"}`, "", "'", "日本", "CLIENT=\"\\n\"", "[23]..")
+
+	f.Fuzz(func(t *testing.T, servicename, apmTx, apmTxRegex, apmTxExclude, apmTxExcludeRegex, filter,
+		goodStatusRegex string) {
+		options := map[string]string{
+			"servicename":            servicename,
+			"apm_tx":                 apmTx,
+			"apm_tx_regex":           apmTxRegex,
+			"apm_tx_exclude":         apmTxExclude,
+			"apm_tx_exclude_regex":   apmTxExcludeRegex,
+			"filter":                 filter,
+			"good_http_status_regex": goodStatusRegex,
+		}
+		query, err := availability.SLIPlugin(context.TODO(), nil, nil, options)
+		if err != nil {
+			t.Skip()
+		}
+
+		selectors, err := promqltest.ParseSelectors(query)
+		if err != nil {
+			t.Fatalf("%v in query %s", err, query)
+		}
+		if len(selectors) != 2 {
+			t.Fatalf("expected a success and a total selector in query %s", query)
+		}
+
+		job := promqltest.Matcher{Name: "job", Op: "=", Value: servicename + "-metrics"}
+		var general []promqltest.Matcher
+		var transactions []string
+		for _, transaction := range strings.Split(apmTx, ",") {
+			if transaction = strings.TrimSpace(transaction); transaction != "" {
+				transactions = append(transactions, transaction)
+			}
+		}
+		switch len(transactions) {
+		case 0:
+		case 1:
+			general = append(general, promqltest.Matcher{Name: "APM_TRANSACTION", Op: "=", Value: transactions[0]})
+		default:
+			for i, transaction := range transactions {
+				transactions[i] = regexp.QuoteMeta(transaction)
+			}
+			general = append(general,
+				promqltest.Matcher{Name: "APM_TRANSACTION", Op: "=~", Value: strings.Join(transactions, "|")})
+		}
+		for _, matcher := range []promqltest.Matcher{
+			{Name: "APM_TRANSACTION", Op: "=~", Value: apmTxRegex},
+			{Name: "APM_TRANSACTION", Op: "!=", Value: apmTxExclude},
+			{Name: "APM_TRANSACTION", Op: "!~", Value: apmTxExcludeRegex},
+		} {
+			if matcher.Value != "" {
+				general = append(general, matcher)
+			}
+		}
+		if goodStatusRegex == "" {
+			goodStatusRegex = "2.."
+		}
+
+		for _, selector := range selectors {
+			assert.Equal(t, job, selector[0], query)
+			if !strings.Contains(filter, "job") {
+				jobs := 0
+				for _, matcher := range selector {
+					if matcher.Name == "job" {
+						jobs++
+					}
+				}
+				assert.Equal(t, 1, jobs, "no job matcher should be injected in query %s", query)
+			}
+			for _, matcher := range general {
+				assert.Contains(t, selector, matcher, query)
+			}
+		}
+		assert.Contains(t, selectors[0], promqltest.Matcher{Name: "RESPONSE_STATUS", Op: "=~", Value: goodStatusRegex},
+			query)
+		assert.Equal(t, len(selectors[1])+1, len(selectors[0]),
+			"the success selector should only add the status matcher in query %s", query)
+	})
+}
+
+var update = flag.Bool("update", false, "update the golden files of the rendered queries")
+
+func TestSLIPluginGolden(t *testing.T) {
+	tests := map[string]map[string]string{
+		"minimal": {"servicename": "demandproduct"},
+		"all-options": {
+			"servicename":            "demandproduct",
+			"apm_tx":                 "/product/full",
+			"filter":                 `CLIENT="TRIPADVISOR"`,
+			"success_filter":         `REQUEST_SIZE_BUCKET="FIFTY"`,
+			"good_http_status_regex": "2..",
+			"exclude_filter":         `REGION="test"`,
+		},
+	}
+
+	for name, options := range tests {
+		t.Run(name, func(t *testing.T) {
+			asserts := assert.New(t)
+			golden := filepath.Join("testdata", name+".golden")
+
+			gotQuery, err := availability.SLIPlugin(context.TODO(), nil, nil, options)
+			if !asserts.NoError(err) {
+				return
+			}
+
+			if *update {
+				asserts.NoError(os.WriteFile(golden, []byte(gotQuery), 0o600))
+			}
+
+			expQuery, err := os.ReadFile(golden)
+			if asserts.NoError(err) {
+				asserts.Equal(string(expQuery), gotQuery)
+			}
+		})
+	}
+}
+
+func TestValidateKnownOptions(t *testing.T) {
+	tests := map[string]struct {
+		options map[string]string
+		expErr  string
+	}{
+		"Known options should be valid.": {
+			options: map[string]string{"servicename": "demandproduct", "apm_tx": "/product/full"},
+		},
+		"A misspelled option should suggest the closest option.": {
+			options: map[string]string{"servicenmae": "demandproduct"},
+			expErr:  "option 'servicenmae': unknown option: did you mean 'servicename'?",
+		},
+		"A partial option should suggest the option containing it.": {
+			options: map[string]string{"status_regex": "(2..|404)"},
+			expErr:  "option 'status_regex': unknown option: did you mean 'good_http_status_regex'?",
+		},
+		"All unknown options should be reported.": {
+			options: map[string]string{"zzz": "1", "apm_txx": "/product/full"},
+			expErr:  "option 'apm_txx': unknown option: did you mean 'apm_tx'?; option 'zzz': unknown option",
+		},
+		"Unknown options should be allowed with the opt-out.": {
+			options: map[string]string{"future_option": "1", "allow_unknown_options": "true"},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			asserts := assert.New(t)
+
+			err := availability.ValidateKnownOptions(test.options, availability.GeneralOptions)
+
+			if test.expErr != "" {
+				asserts.EqualError(err, test.expErr)
+			} else {
+				asserts.NoError(err)
+			}
+		})
+	}
+}
+
+func TestSLIPluginValidationErrors(t *testing.T) {
+	asserts := assert.New(t)
+
+	_, err := availability.SLIPlugin(context.TODO(), nil, nil, map[string]string{
+		"apm_tx_regex":          "([xyz",
+		"exclude_health_checks": "maybe",
+		"status_regex":          "2..",
+	})
+
+	var validationErrors availability.ValidationErrors
+	if asserts.True(errors.As(err, &validationErrors)) {
+		asserts.Len(validationErrors, 4)
+	}
+	asserts.True(errors.Is(err, availability.ErrUnknownOption))
+	asserts.True(errors.Is(err, availability.ErrMissingMandatory))
+	asserts.True(errors.Is(err, availability.ErrInvalidRegex))
+	asserts.True(errors.Is(err, availability.ErrInvalidValue))
+	asserts.False(errors.Is(err, availability.ErrOutOfRange))
+
+	var optionError *availability.OptionError
+	if asserts.True(errors.As(err, &optionError)) {
+		asserts.Equal("status_regex", optionError.Option)
+	}
+	asserts.EqualError(err, "option 'status_regex': unknown option: did you mean 'good_http_status_regex'?; "+
+		"option 'servicename': missing mandatory option; "+
+		"option 'apm_tx_regex' with value '([xyz': invalid regex: error parsing regexp: missing closing ]: `[xyz`; "+
+		"option 'exclude_health_checks' with value 'maybe': invalid value: needs to be a boolean")
+}
+
+func TestEvaluateOptionRules(t *testing.T) {
+	rules := []availability.OptionRule{
+		{Kind: availability.MutuallyExclusive, Option: "a", Others: []string{"b", "c"}, Message: "use one"},
+		{Kind: availability.Requires, Option: "d", Others: []string{"a", "b"}, Message: "d needs a or b"},
+		{Kind: availability.ImpliesWarning, Option: "e", Others: []string{"a"}, Message: "e with a"},
+		{Kind: availability.Requires, Option: "apm_tx_case_insensitive", Others: []string{"a"}, Message: "needs a"},
+	}
+
+	tests := map[string]struct {
+		options     map[string]string
+		expWarnings []availability.OptionWarning
+		expErr      error
+	}{
+		"Options without relations should be valid.": {
+			options: map[string]string{"a": "x", "d": "y"},
+		},
+		"Mutually exclusive options should fail.": {
+			options: map[string]string{"a": "x", "c": "y"},
+			expErr:  availability.ErrConflictingOptions,
+		},
+		"Missing required options should fail.": {
+			options: map[string]string{"d": "true"},
+			expErr:  availability.ErrMissingRequiredOption,
+		},
+		"A false boolean option should not be considered as set.": {
+			options: map[string]string{"apm_tx_case_insensitive": "false"},
+		},
+		"A true boolean option should be considered as set.": {
+			options: map[string]string{"apm_tx_case_insensitive": "T"},
+			expErr:  availability.ErrMissingRequiredOption,
+		},
+		"A false looking value of an option that is not a boolean should be considered as set.": {
+			options: map[string]string{"d": "false"},
+			expErr:  availability.ErrMissingRequiredOption,
+		},
+		"Implied warnings should be returned.": {
+			options:     map[string]string{"a": "x", "e": "y"},
+			expWarnings: []availability.OptionWarning{{Option: "e", Message: "e with a"}},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			asserts := assert.New(t)
+
+			warnings, err := availability.EvaluateOptionRules(test.options, rules)
+
+			asserts.Equal(test.expWarnings, warnings)
+			if test.expErr != nil {
+				asserts.ErrorIs(err, test.expErr)
+			} else {
+				asserts.NoError(err)
+			}
+		})
+	}
+}
+
+func TestValidateOptionsWarnings(t *testing.T) {
+	asserts := assert.New(t)
+
+	warnings, err := availability.ValidateOptions(map[string]string{
+		"servicename":            "demandproduct",
+		"good_http_status_regex": "[2-5]..",
+		"bad_http_status_regex":  "503",
+	})
+
+	asserts.NoError(err)
+	asserts.Equal([]availability.OptionWarning{{
+		Option:  "bad_http_status_regex",
+		Message: "is set together with good_http_status_regex, stick to one of them for readability",
+		Code:    availability.AdvisoryGoodAndBadStatusRegex,
+	}}, warnings)
+}
+
+func TestGetGoodStatusCodes(t *testing.T) {
+	tests := map[string]struct {
+		options map[string]string
+		enforce bool
+		exp     []string
+	}{
+		"Without status regexes every status code should be good when not enforced.": {
+			options: map[string]string{},
+			exp:     nil,
+		},
+		"Good status regex should select the good status codes.": {
+			options: map[string]string{"good_http_status_regex": "20[0-2]"},
+			exp:     []string{"200", "201", "202"},
+		},
+		"Bad status codes should be removed from good ones.": {
+			options: map[string]string{"good_http_status_regex": "20[0-2]", "bad_http_status_regex": "201"},
+			exp:     []string{"200", "202"},
+		},
+		"Excluded status codes should never be good.": {
+			options: map[string]string{
+				"good_http_status_regex":               "20[0-2]",
+				"exclude_from_total_http_status_regex": "202",
+			},
+			exp: []string{"200", "201"},
+		},
+		"Regexes should be anchored like Prometheus does.": {
+			options: map[string]string{"good_http_status_regex": "20|2000|302"},
+			exp:     []string{"302"},
+		},
+		"The gRPC profile should select from the gRPC status codes.": {
+			options: map[string]string{"metric_profile": "grpc_request_elapsed_time_ms", "good_http_status_regex": "OK|NOT_.*"},
+			exp:     []string{"OK", "NOT_FOUND"},
+		},
+		"The gRPC profile should default to OK when enforced.": {
+			options: map[string]string{"metric_profile": "grpc_request_elapsed_time_ms"},
+			enforce: true,
+			exp:     []string{"OK"},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			codes, err := availability.GetGoodStatusCodes(test.options, test.enforce)
+			assert.NoError(t, err)
+			if test.exp != nil {
+				assert.Equal(t, test.exp, codes)
+			} else {
+				assert.Len(t, codes, 500)
+			}
+		})
+	}
+}
+
+func TestValidateStatusCodes(t *testing.T) {
+	tests := map[string]struct {
+		options map[string]string
+		enforce bool
+		expErr  string
+	}{
+		"Without status regexes nothing should be validated.": {
+			options: map[string]string{},
+		},
+		"The enforced default should be valid.": {
+			options: map[string]string{},
+			enforce: true,
+		},
+		"A good regex matching no status code should fail.": {
+			options: map[string]string{"good_http_status_regex": "2"},
+			expErr: "option 'good_http_status_regex' with value '2': invalid status code set: " +
+				"no status code between 100 and 599 is considered good",
+		},
+		"A good regex matching every status code should fail.": {
+			options: map[string]string{"good_http_status_regex": "..."},
+			expErr: "option 'good_http_status_regex' with value '...': invalid status code set: " +
+				"every status code between 100 and 599 is considered good",
+		},
+		"A bad regex excluding every good status code should fail.": {
+			options: map[string]string{"good_http_status_regex": "2..", "bad_http_status_regex": "2.."},
+			expErr: "option 'good_http_status_regex' with value '2..': invalid status code set: " +
+				"no status code between 100 and 599 is considered good",
+		},
+		"A bad regex shadowed by the good regex should fail.": {
+			options: map[string]string{"good_http_status_regex": "2..", "bad_http_status_regex": "[404|302]"},
+			expErr: "option 'bad_http_status_regex' with value '[404|302]': invalid status code set: " +
+				"is shadowed by good_http_status_regex, it excludes none of the status codes considered good",
+		},
+		"A bad regex only should be checked against every status code.": {
+			options: map[string]string{"bad_http_status_regex": "5.."},
+		},
+		"Excluded status codes should not count as good ones.": {
+			options: map[string]string{
+				"good_http_status_regex":               "[1-4]..",
+				"exclude_from_total_http_status_regex": "5..",
+			},
+			expErr: "option 'good_http_status_regex' with value '[1-4]..': invalid status code set: " +
+				"every status code between 100 and 599 is considered good",
+		},
+		"Invalid regexes should be left to the general validation.": {
+			options: map[string]string{"good_http_status_regex": "([xyz"},
+		},
+		"A good regex matching no gRPC status code should fail.": {
+			options: map[string]string{"metric_profile": "grpc_request_elapsed_time_ms", "good_http_status_regex": "2.."},
+			expErr: "option 'good_http_status_regex' with value '2..': invalid status code set: " +
+				"no gRPC status code is considered good",
+		},
+		"A good regex matching some gRPC status codes should be valid.": {
+			options: map[string]string{"metric_profile": "grpc_request_elapsed_time_ms",
+				"good_http_status_regex": "OK|NOT_FOUND"},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := availability.ValidateStatusCodes(test.options, test.enforce)
+			if test.expErr != "" {
+				assert.EqualError(t, err, test.expErr)
+				assert.ErrorIs(t, err, availability.ErrStatusCodeSet)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestLintRegexes(t *testing.T) {
+	tests := map[string]struct {
+		options map[string]string
+		exp     []availability.OptionWarning
+	}{
+		"Regexes without findings should not be linted.": {
+			options: map[string]string{"apm_tx_regex": "/product/.*", "good_http_status_regex": "(2..|404)"},
+		},
+		"Redundant anchors should be linted.": {
+			options: map[string]string{"apm_tx_regex": "^/product.*$", "apm_tx_exclude_regex": `/price\$`},
+			exp: []availability.OptionWarning{{Option: "apm_tx_regex",
+				Message: "has redundant anchors, Prometheus fully anchors regex matchers", Code: availability.AdvisoryRegexLint}},
+		},
+		"Regexes matching everything should be linted.": {
+			options: map[string]string{"apm_tx_exclude_regex": ".*"},
+			exp: []availability.OptionWarning{{Option: "apm_tx_exclude_regex",
+				Message: "matches everything, leave the option unset instead", Code: availability.AdvisoryRegexLint}},
+		},
+		"Regexes matching nothing should be linted.": {
+			options: map[string]string{"apm_tx_regex": `[^\x00-\x{10FFFF}]`},
+			exp:     []availability.OptionWarning{{Option: "apm_tx_regex", Message: "matches nothing", Code: availability.AdvisoryRegexLint}},
+		},
+		"Status regexes not matching a status code should be linted.": {
+			options: map[string]string{"bad_http_status_regex": "[404|302]", "apm_tx_regex": "[404|302]"},
+			exp: []availability.OptionWarning{{Option: "bad_http_status_regex",
+				Message: "can never match a status code between 100 and 599", Code: availability.AdvisoryRegexLint}},
+		},
+		"Large alternations should be linted.": {
+			options: map[string]string{"apm_tx_regex": "a" + strings.Repeat("|a", 50) + "|[|]"},
+			exp: []availability.OptionWarning{{Option: "apm_tx_regex",
+				Message: "has 52 alternatives, more than 50 make queries slow and hard to read", Code: availability.AdvisoryRegexLint}},
+		},
+		"Invalid regexes should be left to the general validation.": {
+			options: map[string]string{"apm_tx_regex": "^([xyz"},
+		},
+		"gRPC status regexes not matching a gRPC status code should be linted.": {
+			options: map[string]string{"metric_profile": "grpc_request_elapsed_time_ms", "bad_http_status_regex": "5.."},
+			exp: []availability.OptionWarning{{Option: "bad_http_status_regex",
+				Message: "can never match a gRPC status code", Code: availability.AdvisoryRegexLint}},
+		},
+		"Doubled backslashes from the previous unescaped values should be linted.": {
+			options: map[string]string{"apm_tx_regex": `/product\\.json`, "apm_tx_exclude_regex": `/price\.json`},
+			exp: []availability.OptionWarning{{Option: "apm_tx_regex",
+				Message: `has a doubled backslash, which matches a literal backslash since regex options are escaped by ` +
+					`the plugin, write \. instead of \\.`, Code: availability.AdvisoryRegexLint}},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			warnings, err := availability.LintRegexes(test.options)
+			assert.NoError(t, err)
+			assert.Equal(t, test.exp, warnings)
+		})
+	}
+}
+
+func TestLintRegexesStrict(t *testing.T) {
+	asserts := assert.New(t)
+
+	warnings, err := availability.LintRegexes(map[string]string{
+		"apm_tx_regex":      "^/product.*",
+		"strict_regex_lint": "true",
+	})
+
+	asserts.Empty(warnings)
+	asserts.ErrorIs(err, availability.ErrRegexLint)
+	asserts.EqualError(err, "option 'apm_tx_regex' with value '^/product.*': regex lint: "+
+		"has redundant anchors, Prometheus fully anchors regex matchers")
+}
+
+func TestAdvise(t *testing.T) {
+	tests := map[string]struct {
+		options map[string]string
+		exp     []string
+	}{
+		"Following the best practices should not result in advisories.": {
+			options: map[string]string{"servicename": "demandproduct", "apm_tx": "/product/full"},
+		},
+		"Not setting any transaction should be advised against.": {
+			options: map[string]string{"servicename": "demandproduct"},
+			exp: []string{"missing-apm-tx: option 'apm_tx': is not set, " +
+				"an SLO should usually be limited to the APM_TRANSACTIONs of a single use case"},
+		},
+		"Validation warnings should be advisories.": {
+			options: map[string]string{"servicename": "demandproduct", "apm_tx_regex": "^/product.*",
+				"good_http_status_regex": "[2-5]..", "bad_http_status_regex": "503"},
+			exp: []string{
+				"good-and-bad-status-regex: option 'bad_http_status_regex': " +
+					"is set together with good_http_status_regex, stick to one of them for readability",
+				"regex-lint: option 'apm_tx_regex': has redundant anchors, Prometheus fully anchors regex matchers",
+			},
+		},
+		"Filters duplicating first-class options should be advised against.": {
+			options: map[string]string{"servicename": "demandproduct", "apm_tx": "/product/full",
+				"filter": `APM_TRANSACTION!="/ping", CLIENT="TRIPADVISOR"`, "success_filter": `RESPONSE_STATUS=~"2.."`},
+			exp: []string{
+				`filter-duplicates-option: option 'filter': matcher APM_TRANSACTION!="/ping" duplicates a first-class ` +
+					"option, use apm_tx, apm_tx_glob, apm_tx_regex, apm_tx_exclude or apm_tx_exclude_regex instead",
+				`filter-duplicates-option: option 'success_filter': matcher RESPONSE_STATUS=~"2.." duplicates a ` +
+					"first-class option, use good_http_status_regex, bad_http_status_regex or " +
+					"exclude_from_total_http_status_regex instead",
+			},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			var advisories []string
+			for _, advisory := range availability.Advise(test.options) {
+				advisories = append(advisories, advisory.String())
+			}
+			assert.Equal(t, test.exp, advisories)
+		})
+	}
+}
+
+func TestQueryBuilder(t *testing.T) {
+	tests := map[string]struct {
+		builder *availability.QueryBuilder
+		options map[string]string
+		expErr  bool
+	}{
+		"The defaults should match the plugin defaults.": {
+			builder: availability.NewQueryBuilder("demandproduct"),
+			options: map[string]string{"servicename": "demandproduct"},
+		},
+		"Typed options should match the plugin options.": {
+			builder: availability.NewQueryBuilder("demandproduct").
+				ApmTx("/product/full", "/product/lite").
+				Filter(availability.LabelMatcher{Name: "CLIENT", Op: "=", Value: "TRIPADVISOR"}).
+				GoodHTTPStatusRegex("[2-4]..").
+				ExcludeHealthChecks(false),
+			options: map[string]string{"servicename": "demandproduct", "apm_tx": "/product/full,/product/lite",
+				"filter": `CLIENT="TRIPADVISOR"`, "good_http_status_regex": "[2-4]..", "exclude_health_checks": "false"},
+		},
+		"An empty success filter should prevent the default good status regex.": {
+			builder: availability.NewQueryBuilder("demandproduct").ApmTx("/product/full").SuccessFilter(),
+			options: map[string]string{"servicename": "demandproduct", "apm_tx": "/product/full", "success_filter": " "},
+		},
+		"Invalid options should fail.": {
+			builder: availability.NewQueryBuilder("demandproduct").ApmTx("/product/full").ApmTxRegex("/product/.*"),
+			expErr:  true,
+		},
+		"Job options should match the plugin options.": {
+			builder: availability.NewQueryBuilder("demandproduct").JobSuffix("-pods").ApmTx("/product/full"),
+			options: map[string]string{"servicename": "demandproduct", "job_suffix": "-pods", "apm_tx": "/product/full"},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			asserts := assert.New(t)
+
+			query, err := test.builder.Build()
+			if test.expErr {
+				asserts.ErrorIs(err, availability.ErrConflictingOptions)
+				return
+			}
+			if asserts.NoError(err) {
+				expQuery, err := availability.SLIPlugin(context.TODO(), nil, nil, test.options)
+				asserts.NoError(err)
+				asserts.Equal(expQuery, query)
+				asserts.Equal(test.options, test.builder.Options().Map())
+			}
+		})
+	}
+}
+
+func TestParseOptions(t *testing.T) {
+	asserts := assert.New(t)
+
+	options := map[string]string{
+		"servicename":             "demandproduct",
+		"apm_tx_glob":             "/product/*, /price/**",
+		"apm_tx_case_insensitive": "true",
+		"success_filter":          `{o="g", p=~"h|i"}`,
+		"exclude_filter":          `CLIENT="BOT"`,
+	}
+	parsed, err := availability.ParseOptions(options)
+
+	if asserts.NoError(err) {
+		asserts.Equal([]string{"/product/*", "/price/**"}, parsed.ApmTxGlob)
+		asserts.True(parsed.ApmTxCaseInsensitive)
+		asserts.True(parsed.ExcludeHealthChecks)
+		asserts.Equal([]availability.LabelMatcher{{Name: "o", Op: "=", Value: "g"}, {Name: "p", Op: "=~", Value: "h|i"}},
+			parsed.SuccessFilter)
+
+		query, err := parsed.Query()
+		asserts.NoError(err)
+		expQuery, err := availability.SLIPlugin(context.TODO(), nil, nil, options)
+		asserts.NoError(err)
+		asserts.Equal(expQuery, query)
+	}
+
+	_, err = availability.ParseOptions(map[string]string{"apm_tx": "/product/full"})
+	asserts.ErrorIs(err, availability.ErrMissingMandatory)
+}
+
+func TestOptionSchema(t *testing.T) {
+	asserts := assert.New(t)
+
+	names := map[string]bool{}
+	for _, spec := range availability.OptionSchema {
+		asserts.False(names[spec.Name], "duplicate option %s", spec.Name)
+		names[spec.Name] = true
+		asserts.NotEmpty(spec.Description, spec.Name)
+		asserts.NotEmpty(spec.Example, spec.Name)
+		asserts.NoError(availability.ValidateOptionSchema(map[string]string{spec.Name: spec.Example},
+			availability.OptionSchema), spec.Name)
+		if spec.Pattern != "" {
+			asserts.Regexp(spec.Pattern, spec.Example, spec.Name)
+		}
+	}
+	asserts.Equal(availability.GeneralOptions, availability.OptionNames(availability.OptionSchema))
+}
+
+func TestValidateOptionSchema(t *testing.T) {
+	schema := []availability.OptionSpec{
+		{Name: "mode", Type: availability.StringOption, AllowedValues: []string{"fast", "slow"}},
+		{Name: "regex", Type: availability.RegexOption},
+		{Name: "filter", Type: availability.FilterOption},
+		{Name: "enabled", Type: availability.BoolOption},
+	}
+
+	tests := map[string]struct {
+		options map[string]string
+		expErr  string
+	}{
+		"Valid values should not fail.": {
+			options: map[string]string{"mode": "fast", "regex": "a|b", "filter": `a="b"`, "enabled": "true"},
+		},
+		"Blank values should be ignored.": {
+			options: map[string]string{"mode": " ", "regex": "", "filter": " ", "enabled": ""},
+		},
+		"Values not matching their type or allowed values should fail.": {
+			options: map[string]string{"mode": "medium", "regex": "([xyz", "filter": `a=b`, "enabled": "maybe"},
+			expErr: "option 'mode' with value 'medium': invalid value: needs to be one of fast, slow; " +
+				"option 'regex' with value '([xyz': invalid regex: error parsing regexp: missing closing ]: `[xyz`; " +
+				"option 'filter' with value 'a=b': invalid filter: invalid filter at position 3: " +
+				"expected quoted label value, got 'b'; " +
+				"option 'enabled' with value 'maybe': invalid value: needs to be a boolean",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := availability.ValidateOptionSchema(test.options, schema)
+			if test.expErr != "" {
+				assert.EqualError(t, err, test.expErr)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestExplain(t *testing.T) {
+	tests := map[string]struct {
+		options map[string]string
+		exp     string
+		expErr  bool
+	}{
+		"The default status should be explained.": {
+			options: map[string]string{"servicename": "demandproduct", "apm_tx": "/product/full"},
+			exp: "Good = requests to demandproduct-metrics, APM_TRANSACTION=/product/full; good: 2xx; " +
+				"Total = the same requests with any status; without requests in the window the SLI reports no errors.",
+		},
+		"Every filter should be explained.": {
+			options: map[string]string{"servicename": "demandproduct", "apm_tx_glob": "/product/*",
+				"filter": `CLIENT="TRIPADVISOR"`, "good_http_status_regex": "(2..|404)",
+				"exclude_from_total_http_status_regex": "429", "exclude_filter": `CLIENT="BOT", CLIENT="CRAWLER"`},
+			exp: `Good = requests to demandproduct-metrics, APM_TRANSACTION matching /product/[^/]*, CLIENT="TRIPADVISOR", ` +
+				`excluding 429, excluding requests with CLIENT="BOT" or CLIENT="CRAWLER", excluding health checks; ` +
+				"good: 2xx, 404; Total = the same requests with any status; " +
+				"without requests in the window the SLI reports no errors.",
+		},
+		"Invalid options should not be explained.": {
+			options: map[string]string{"servicename": "demandproduct", "good_http_status_regex": "(2.."},
+			expErr:  true,
+		},
+		"A job regex should be explained.": {
+			options: map[string]string{"job_regex": "demandproduct-(metrics|canary)", "apm_tx": "/product/full"},
+			exp: "Good = requests to jobs matching demandproduct-(metrics|canary), APM_TRANSACTION=/product/full; " +
+				"good: 2xx; Total = the same requests with any status; " +
+				"without requests in the window the SLI reports no errors.",
+		},
+		"The gRPC status should be explained.": {
+			options: map[string]string{"servicename": "demandproduct", "metric_profile": "grpc_request_elapsed_time_ms",
+				"apm_tx": "demand.Product/Get", "good_http_status_regex": "OK|NOT_FOUND"},
+			exp: "Good = requests to demandproduct-metrics, GRPC_METHOD=demand.Product/Get; good: OK, NOT_FOUND; " +
+				"Total = the same requests with any status; without requests in the window the SLI reports no errors.",
+		},
+		"Excluded and good status codes should be explained as one list each.": {
+			options: map[string]string{"servicename": "demandproduct", "apm_tx": "/product/full",
+				"exclude_from_total_http_status_regex": "4..", "bad_http_status_regex": "5.."},
+			exp: "Good = requests to demandproduct-metrics, APM_TRANSACTION=/product/full, excluding 4xx; good: 1xx, 2xx, 3xx; " +
+				"Total = the same requests with any status; without requests in the window the SLI reports no errors.",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			explanation, err := availability.Explain(test.options)
+			if test.expErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.exp, explanation)
+		})
+	}
+}
+
+func TestDescribeStatusCodes(t *testing.T) {
+	tests := map[string]struct {
+		codes []string
+		exp   string
+	}{
+		"No status codes should be described.":        {exp: "none"},
+		"Status names should be listed as they are.":  {codes: []string{"OK", "NOT_FOUND"}, exp: "OK, NOT_FOUND"},
+		"Whole classes should be shortened.":          {codes: statusCodes(200, 399), exp: "2xx, 3xx"},
+		"Partial classes should be listed as ranges.": {codes: append(statusCodes(200, 204), "404"), exp: "200-204, 404"},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, test.exp, availability.DescribeStatusCodes(test.codes))
+		})
+	}
+}
+
+func statusCodes(from int, to int) []string {
+	var codes []string
+	for code := from; code <= to; code++ {
+		codes = append(codes, strconv.Itoa(code))
+	}
+	return codes
+}
+
+// withoutTraceHeader removes the trace header comment lines of a query.
+func withoutTraceHeader(query string) string {
+	var lines []string
+	for _, line := range strings.Split(query, "\n") {
+		if !strings.HasPrefix(line, "#") {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestTraceHeader(t *testing.T) {
+	asserts := assert.New(t)
+
+	query, err := availability.SLIPlugin(context.TODO(), nil, nil,
+		map[string]string{"servicename": "demandproduct", "apm_tx": "/product/full"})
+	asserts.NoError(err)
+	asserts.True(strings.HasPrefix(query, "\n# plugin: viator-sloth-plugins/request_elapsed_time_ms/availability\n"+
+		"# options: sha256:"), query, "the header should be prepended by default")
+
+	equivalent, err := availability.SLIPlugin(context.TODO(), nil, nil,
+		map[string]string{"servicename": "demandproduct", "apm_tx": " /product/full ", "exclude_health_checks": "true",
+			"trace_header": "TRUE"})
+	asserts.NoError(err)
+	asserts.Equal(query, equivalent, "equivalent options should share the options hash")
+
+	query, err = availability.SLIPlugin(context.TODO(), nil, nil,
+		map[string]string{"servicename": "demandproduct", "trace_header": "false"})
+	asserts.NoError(err)
+	asserts.True(strings.HasPrefix(query, "\n1 - (("), query)
+}
+
+func TestOptionsHash(t *testing.T) {
+	asserts := assert.New(t)
+
+	hash := availability.OptionsHash(map[string]string{"servicename": "demandproduct", "apm_tx": "/product/full"})
+	asserts.Regexp(`^sha256:[0-9a-f]{12}$`, hash)
+	asserts.Equal(hash, availability.OptionsHash(map[string]string{"apm_tx": "/product/full", "servicename": "demandproduct"}))
+	asserts.NotEqual(hash, availability.OptionsHash(map[string]string{"servicename": "demandproduct", "apm_tx": "/product/lite"}))
+}
+
+func TestApplySLODefaults(t *testing.T) {
+	tests := map[string]struct {
+		meta    map[string]string
+		labels  map[string]string
+		options map[string]string
+		exp     map[string]string
+		expErr  error
+	}{
+		"Options without SLO metadata should be kept.": {
+			options: map[string]string{"servicename": "demandproduct"},
+			exp:     map[string]string{"servicename": "demandproduct"},
+		},
+		"The servicename should default to the service of the SLO.": {
+			meta:    map[string]string{"service": "demandproduct"},
+			options: map[string]string{"apm_tx": "/product/full"},
+			exp:     map[string]string{"servicename": "demandproduct", "apm_tx": "/product/full"},
+		},
+		"SLO labels should be appended to the filter as escaped matchers.": {
+			labels: map[string]string{"env": "prod", "region": `us"east`},
+			options: map[string]string{"servicename": "demandproduct", "filter": `CLIENT="TRIPADVISOR"`,
+				"filter_from_labels": "env, region"},
+			exp: map[string]string{"servicename": "demandproduct",
+				"filter": `CLIENT="TRIPADVISOR", env="prod", region="us\"east"`},
+		},
+		"A label missing on the SLO should fail.": {
+			labels:  map[string]string{"env": "prod"},
+			options: map[string]string{"servicename": "demandproduct", "filter_from_labels": "env,region"},
+			expErr:  availability.ErrMissingLabel,
+		},
+		"An invalid label name should fail.": {
+			labels:  map[string]string{"env-name": "prod"},
+			options: map[string]string{"servicename": "demandproduct", "filter_from_labels": "env-name"},
+			expErr:  availability.ErrInvalidValue,
+		},
+		"SLO labels should be appended to a braced filter.": {
+			labels: map[string]string{"env": "prod"},
+			options: map[string]string{"servicename": "demandproduct", "filter": `{CLIENT="a"}`,
+				"filter_from_labels": "env"},
+			exp: map[string]string{"servicename": "demandproduct", "filter": `CLIENT="a", env="prod"`},
+		},
+		"An invalid filter should be kept for the validation to report it.": {
+			labels:  map[string]string{"env": "prod"},
+			options: map[string]string{"servicename": "demandproduct", "filter": `CLIENT`, "filter_from_labels": "env"},
+			exp:     map[string]string{"servicename": "demandproduct", "filter": `CLIENT`},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			completed, err := availability.ApplySLODefaults(test.meta, test.labels, test.options)
+			if test.expErr != nil {
+				assert.ErrorIs(t, err, test.expErr)
+				return
 			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.exp, completed)
 		})
 	}
 }
+
+func TestMetricProfile(t *testing.T) {
+	asserts := assert.New(t)
+
+	defaultProfiles := availability.MetricProfiles
+	defer func() { availability.MetricProfiles = defaultProfiles }()
+	availability.MetricProfiles = append(append([]availability.MetricProfile{}, defaultProfiles...),
+		availability.MetricProfile{Name: "client", Metric: "client_request:ELAPSED_TIME_MS", TransactionLabel: "CLIENT_TX",
+			StatusLabel: "CLIENT_STATUS", JobSuffix: "-client-metrics", GoodStatusRegex: "2.."})
+
+	options := availability.FilterOptions{MetricProfile: "client", ServiceName: "demandproduct",
+		ApmTx: []string{"/product/full"}, ExcludeFromTotalHTTPStatusRegex: "4..", ExcludeHealthChecks: true, TraceHeader: true}
+	asserts.Equal([]availability.LabelMatcher{
+		{Name: "job", Op: "=", Value: "demandproduct-client-metrics"},
+		{Name: "CLIENT_TX", Op: "=", Value: "/product/full"},
+		{Name: "CLIENT_STATUS", Op: "!~", Value: "4.."},
+	}, options.GeneralMatchers())
+	asserts.Equal([]availability.LabelMatcher{{Name: "CLIENT_STATUS", Op: "=~", Value: "2.."}},
+		options.SuccessMatchers(true))
+	asserts.Equal(map[string]string{"metric_profile": "client", "servicename": "demandproduct", "apm_tx": "/product/full",
+		"exclude_from_total_http_status_regex": "4.."}, options.Map())
+
+	options.JobSuffix = "-metrics"
+	asserts.Equal("-metrics", options.Map()["job_suffix"], "a job suffix differing from the profile should be kept")
+
+	profile, ok := availability.GetMetricProfile("")
+	asserts.True(ok)
+	asserts.Equal("request:ELAPSED_TIME_MS", profile.Metric)
+	_, ok = availability.GetMetricProfile("unknown")
+	asserts.False(ok)
+}
@@ -1,132 +1,1988 @@
 package latency
 
 import (
-	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"math"
 	"regexp"
+	"regexp/syntax"
+	"sort"
 	"strconv"
 	"strings"
-	"text/template"
 )
 
-var generalFilterTpl = template.Must(template.New("").Option("missingkey=error").Parse(
-	`job="{{.servicename}}-metrics"
-	{{- if .apm_tx -}}
-	  , APM_TRANSACTION="{{.apm_tx}}"
-	{{- end -}}
-	{{- if .apm_tx_regex -}}
-	  , APM_TRANSACTION=~"{{.apm_tx_regex}}"
-	{{- end -}}
-    {{- .filter -}}
-`))
-
-var successFilterTpl = template.Must(template.New("").Option("missingkey=error").Parse(
-	`
-    {{- if .good_http_status_regex -}}
-      , RESPONSE_STATUS=~"{{.good_http_status_regex}}"
-    {{- end -}}
-    {{- if .bad_http_status_regex -}}
-      , RESPONSE_STATUS!~"{{.bad_http_status_regex}}"
-    {{- end -}}
-    {{- .success_filter -}}
-`))
+// DefaultHealthCheckApmTxRegex matches the health and readiness transactions excluded by `exclude_health_checks`.
+const DefaultHealthCheckApmTxRegex = "/ping|/health|/healthcheck|/healthz|/ready|/readiness|/readyz|/live|/liveness|/livez|/actuator/health(/.*)?"
+
+const serviceNamePattern = `^[a-zA-Z0-9]([a-zA-Z0-9._-]*[a-zA-Z0-9])?$`
+
+// DefaultJobSuffix is appended to `servicename` for the Prometheus job, unless `job_suffix` is set.
+const DefaultJobSuffix = "-metrics"
+
+const jobSuffixPattern = `^[a-zA-Z0-9._-]+$`
+
+var regxJobSuffix = regexp.MustCompile(jobSuffixPattern)
+
+const labelNameListPattern = `^\s*[a-zA-Z_][a-zA-Z0-9_]*\s*(,\s*[a-zA-Z_][a-zA-Z0-9_]*\s*)*$`
+
+var regxServiceName = regexp.MustCompile(serviceNamePattern)
 
 func GetServiceName(options map[string]string) (string, error) {
+	servicename, err := getServiceName(options)
+	if err != nil {
+		return "", err
+	}
+	return servicename, nil
+}
+
+// getServiceName is GetServiceName returning the typed *OptionError for ValidationErrors.Append.
+func getServiceName(options map[string]string) (string, *OptionError) {
 	servicename := strings.TrimSpace(options["servicename"])
 
-	if servicename == "" {
-		return "", fmt.Errorf("servicename is mandatory")
+	if servicename == "" {
+		return "", &OptionError{Option: "servicename", Err: ErrMissingMandatory}
+	}
+
+	if !regxServiceName.MatchString(servicename) {
+		return "", &OptionError{Option: "servicename", Value: servicename, Err: ErrInvalidValue,
+			Reason: "only letters, digits, '.', '_' and '-' are allowed"}
+	}
+
+	return servicename, nil
+}
+
+// ApplySLODefaults returns a copy of the options completed from the sloth SLO metadata and labels
+// passed to SLIPlugin: `servicename` defaults to the service of the spec and every label of the spec listed by
+// `filter_from_labels` is added to `filter` as an exact matcher, after which `filter_from_labels` is left out.
+func ApplySLODefaults(meta map[string]string, labels map[string]string, options map[string]string) (map[string]string,
+	error) {
+	completed := make(map[string]string, len(options)+1)
+	for option, value := range options {
+		completed[option] = value
+	}
+
+	if strings.TrimSpace(completed["servicename"]) == "" && strings.TrimSpace(meta["service"]) != "" {
+		completed["servicename"] = strings.TrimSpace(meta["service"])
+	}
+
+	labelNames := SplitOptionList(completed["filter_from_labels"])
+	delete(completed, "filter_from_labels")
+	if len(labelNames) == 0 {
+		return completed, nil
+	}
+
+	var validationErrors ValidationErrors
+	// an invalid `filter` is kept as is, to be reported by the validation of the options
+	matchers, filterErr := ParseMatchers(completed["filter"])
+	for _, name := range labelNames {
+		value, ok := labels[name]
+		switch {
+		case !isLabelName(name):
+			validationErrors.Add("filter_from_labels", options["filter_from_labels"], ErrInvalidValue,
+				fmt.Sprintf("'%s' is not a valid label name", name))
+		case !ok:
+			validationErrors.Add("filter_from_labels", options["filter_from_labels"], ErrMissingLabel,
+				fmt.Sprintf("the spec does not have the label '%s'", name))
+		default:
+			matchers = append(matchers, LabelMatcher{Name: name, Op: "=", Value: value})
+		}
+	}
+	if filterErr == nil {
+		completed["filter"] = FormatMatchers(matchers)
+	}
+	return completed, validationErrors.ErrorOrNil()
+}
+
+// hasJobOption returns whether the job is set with `job` or `job_regex`, so `servicename` is not needed.
+func hasJobOption(options map[string]string) bool {
+	return strings.TrimSpace(options["job"]) != "" || strings.TrimSpace(options["job_regex"]) != ""
+}
+
+// ValidateGeneralExpCommonFilterOptions returns ValidationErrors listing every problem of the general options.
+func ValidateGeneralExpCommonFilterOptions(options map[string]string) error {
+	return validateGeneralExpCommonFilterOptions(options).ErrorOrNil()
+}
+
+func validateGeneralExpCommonFilterOptions(options map[string]string) ValidationErrors {
+	var validationErrors ValidationErrors
+	if _, err := getServiceName(options); err != nil && !(err.Err == ErrMissingMandatory && hasJobOption(options)) {
+		validationErrors.Append(err)
+	}
+	if jobSuffix := strings.TrimSpace(options["job_suffix"]); jobSuffix != "" && !regxJobSuffix.MatchString(jobSuffix) {
+		validationErrors.Add("job_suffix", jobSuffix, ErrInvalidValue, "only letters, digits, '.', '_' and '-' are allowed")
+	}
+
+	validationErrors.Append(validateOptionSchema(options, GeneralOptionSpecs)...)
+
+	if apmTxRegex, err := GetApmTxRegex(options); err == nil && apmTxRegex != options["apm_tx_regex"] {
+		if _, err := regexp.Compile(apmTxRegex); err != nil {
+			validationErrors.Add("apm_tx_regex", apmTxRegex, ErrInvalidRegex, err.Error())
+		}
+	}
+
+	return validationErrors
+}
+
+var (
+	// ErrMissingMandatory is the cause of errors for mandatory options that are not set.
+	ErrMissingMandatory = errors.New("missing mandatory option")
+	// ErrInvalidValue is the cause of errors for option values that can not be parsed or are not allowed.
+	ErrInvalidValue = errors.New("invalid value")
+	// ErrInvalidRegex is the cause of errors for option values that are not valid regexes.
+	ErrInvalidRegex = errors.New("invalid regex")
+	// ErrInvalidFilter is the cause of errors for option values that are not valid label matchers.
+	ErrInvalidFilter = errors.New("invalid filter")
+	// ErrOutOfRange is the cause of errors for option values outside of the allowed range.
+	ErrOutOfRange = errors.New("value out of range")
+	// ErrUnknownOption is the cause of errors for options that are not supported by the plugin.
+	ErrUnknownOption = errors.New("unknown option")
+	// ErrConflictingOptions is the cause of errors for mutually exclusive options that are set together.
+	ErrConflictingOptions = errors.New("conflicting options")
+	// ErrMissingRequiredOption is the cause of errors for options that require another option to be set.
+	ErrMissingRequiredOption = errors.New("missing required option")
+	// ErrStatusCodeSet is the cause of errors for status regexes resulting in a useless set of good status codes.
+	ErrStatusCodeSet = errors.New("invalid status code set")
+	// ErrRegexLint is the cause of errors for regex lint findings when `strict_regex_lint` is true.
+	ErrRegexLint = errors.New("regex lint")
+	// ErrMissingLabel is the cause of errors for SLO labels referenced by options that the SLO does not have.
+	ErrMissingLabel = errors.New("missing SLO label")
+)
+
+// OptionError is a validation problem of a single option,
+// Err is one of the sentinel errors, e.g. ErrInvalidRegex, to be used with errors.Is.
+type OptionError struct {
+	Option string
+	Value  string
+	Reason string
+	Err    error
+}
+
+func (e *OptionError) Error() string {
+	message := fmt.Sprintf("option '%s'", e.Option)
+	if e.Value != "" {
+		message += fmt.Sprintf(" with value '%v'", e.Value)
+	}
+	message += ": " + e.Err.Error()
+	if e.Reason != "" {
+		message += ": " + e.Reason
+	}
+	return message
+}
+
+func (e *OptionError) Unwrap() error {
+	return e.Err
+}
+
+// ValidationErrors lists every validation problem of the options, so they can be shown at once.
+// errors.Is and errors.As match any of the contained errors.
+type ValidationErrors []*OptionError
+
+func (e ValidationErrors) Error() string {
+	messages := make([]string, 0, len(e))
+	for _, err := range e {
+		messages = append(messages, err.Error())
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Is reports whether any of the contained errors matches the target.
+func (e ValidationErrors) Is(target error) bool {
+	for _, err := range e {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As finds the first of the contained errors that matches the target.
+func (e ValidationErrors) As(target interface{}) bool {
+	for _, err := range e {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// Add adds a validation problem of an option.
+func (e *ValidationErrors) Add(option string, value string, err error, reason string) {
+	*e = append(*e, &OptionError{Option: option, Value: value, Reason: reason, Err: err})
+}
+
+// Append adds the validation problems, leaving out nil ones.
+// The validation passes them typed, as the Yaegi interpreter of sloth can not get them back from an error,
+// e.g. with errors.As, so the exported functions returning error wrap an unexported function returning them typed.
+func (e *ValidationErrors) Append(errs ...*OptionError) {
+	for _, err := range errs {
+		if err != nil {
+			*e = append(*e, err)
+		}
+	}
+}
+
+// ErrorOrNil returns nil without any validation problems, so it can be returned as error.
+func (e ValidationErrors) ErrorOrNil() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+// OptionType is the type of an option value, all values are passed as strings by sloth.
+// It is declared before its first use, the Yaegi interpreter of sloth compares with constants declared later wrongly.
+type OptionType string
+
+const (
+	// StringOption values are plain strings.
+	StringOption OptionType = "string"
+	// ListOption values are comma separated strings.
+	ListOption OptionType = "list"
+	// RegexOption values are regexes, fully anchored by Prometheus.
+	RegexOption OptionType = "regex"
+	// FilterOption values are comma separated PromQL label matchers.
+	FilterOption OptionType = "filter"
+	// BoolOption values are booleans.
+	BoolOption OptionType = "bool"
+	// IntOption values are integers.
+	IntOption OptionType = "int"
+)
+
+// RuleKind is the kind of an OptionRule.
+type RuleKind int
+
+const (
+	// MutuallyExclusive rules reject the option being set together with any of the other options.
+	MutuallyExclusive RuleKind = iota
+	// Requires rules reject the option being set without any of the other options.
+	Requires
+	// ImpliesWarning rules warn about the option being set together with any of the other options.
+	ImpliesWarning
+)
+
+// OptionRule declares a relation between an option and other options.
+// Code is the advisory code of the warnings of ImpliesWarning rules.
+type OptionRule struct {
+	Kind    RuleKind
+	Option  string
+	Others  []string
+	Message string
+	Code    string
+}
+
+// OptionWarning is a problem of an option that does not prevent rendering the query.
+type OptionWarning struct {
+	Option  string
+	Message string
+	Code    string
+}
+
+func (w OptionWarning) String() string {
+	return fmt.Sprintf("option '%s': %s", w.Option, w.Message)
+}
+
+// Advisory codes are stable identifiers of best-practice findings, e.g. to be surfaced by CI.
+const (
+	AdvisoryMissingApmTx           = "missing-apm-tx"
+	AdvisoryGoodAndBadStatusRegex  = "good-and-bad-status-regex"
+	AdvisoryIgnoredOption          = "ignored-option"
+	AdvisoryRegexLint              = "regex-lint"
+	AdvisoryFilterDuplicatesOption = "filter-duplicates-option"
+)
+
+// Advisory is a best-practice finding that does not block rendering the query.
+// Option is empty when the advisory does not concern a single option.
+type Advisory struct {
+	Code    string
+	Option  string
+	Message string
+}
+
+func (a Advisory) String() string {
+	if a.Option == "" {
+		return fmt.Sprintf("%s: %s", a.Code, a.Message)
+	}
+	return fmt.Sprintf("%s: option '%s': %s", a.Code, a.Option, a.Message)
+}
+
+// GetGeneralAdvisories returns the advisories of the general options, including the given validation warnings.
+func GetGeneralAdvisories(options map[string]string, warnings []OptionWarning) []Advisory {
+	// an unknown profile is reported by the validation
+	profile, ok := GetMetricProfile(options["metric_profile"])
+	if !ok {
+		profile, _ = GetMetricProfile(DefaultMetricProfile)
+	}
+
+	var advisories []Advisory
+	if !isOptionSet(options, "apm_tx") && !isOptionSet(options, "apm_tx_glob") && !isOptionSet(options, "apm_tx_regex") {
+		advisories = append(advisories, Advisory{Code: AdvisoryMissingApmTx, Option: "apm_tx",
+			Message: fmt.Sprintf("is not set, an SLO should usually be limited to the %ss of a single use case",
+				profile.TransactionLabel)})
+	}
+
+	for _, warning := range warnings {
+		advisories = append(advisories, Advisory{Code: warning.Code, Option: warning.Option, Message: warning.Message})
+	}
+
+	for _, option := range []string{"filter", "success_filter", "exclude_filter"} {
+		matchers, err := ParseMatchers(options[option])
+		if err != nil {
+			continue
+		}
+		for _, matcher := range matchers {
+			if firstClassOptions, ok := profile.firstClassOptions()[matcher.Name]; ok {
+				advisories = append(advisories, Advisory{Code: AdvisoryFilterDuplicatesOption, Option: option,
+					Message: fmt.Sprintf("matcher %s duplicates a first-class option, use %s instead",
+						matcher, firstClassOptions)})
+			}
+		}
+	}
+	return advisories
+}
+
+// GeneralOptionRules are the relations between the general options.
+var GeneralOptionRules = []OptionRule{
+	{Kind: MutuallyExclusive, Option: "job_regex", Others: []string{"job"},
+		Message: "would render conflicting job matchers, use either job or job_regex"},
+	{Kind: MutuallyExclusive, Option: "job_suffix", Others: []string{"job", "job_regex"},
+		Message: "only applies to the job derived from servicename, use either job_suffix or job/job_regex"},
+	{Kind: MutuallyExclusive, Option: "apm_tx_regex", Others: []string{"apm_tx", "apm_tx_glob"},
+		Message: "would render conflicting transaction matchers, use either apm_tx/apm_tx_glob or apm_tx_regex"},
+	{Kind: Requires, Option: "apm_tx_case_insensitive", Others: []string{"apm_tx", "apm_tx_glob", "apm_tx_regex"},
+		Message: "only applies to transactions selected with apm_tx, apm_tx_glob or apm_tx_regex"},
+	{Kind: ImpliesWarning, Option: "bad_http_status_regex", Others: []string{"good_http_status_regex"},
+		Message: "is set together with good_http_status_regex, stick to one of them for readability",
+		Code:    AdvisoryGoodAndBadStatusRegex},
+	{Kind: ImpliesWarning, Option: "health_check_apm_tx_regex", Others: []string{"apm_tx"},
+		Message: "is ignored, as health checks are not excluded when apm_tx is set",
+		Code:    AdvisoryIgnoredOption},
+}
+
+// EvaluateOptionRules returns the warnings of all ImpliesWarning rules
+// and ValidationErrors for all violated MutuallyExclusive and Requires rules.
+func EvaluateOptionRules(options map[string]string, rules []OptionRule) ([]OptionWarning, error) {
+	warnings, validationErrors := evaluateOptionRules(options, rules)
+	return warnings, validationErrors.ErrorOrNil()
+}
+
+func evaluateOptionRules(options map[string]string, rules []OptionRule) ([]OptionWarning, ValidationErrors) {
+	var warnings []OptionWarning
+	var validationErrors ValidationErrors
+	for _, rule := range rules {
+		if !isOptionSet(options, rule.Option) {
+			continue
+		}
+
+		var setOthers []string
+		for _, other := range rule.Others {
+			if isOptionSet(options, other) {
+				setOthers = append(setOthers, other)
+			}
+		}
+
+		// switch on the kind, the Yaegi interpreter of sloth inverts `rule.Kind == X` in switch cases
+		switch rule.Kind {
+		case MutuallyExclusive:
+			if len(setOthers) > 0 {
+				validationErrors.Add(rule.Option, options[rule.Option], ErrConflictingOptions,
+					fmt.Sprintf("can not be set together with %s, %s", strings.Join(setOthers, ", "), rule.Message))
+			}
+		case Requires:
+			if len(setOthers) == 0 {
+				validationErrors.Add(rule.Option, options[rule.Option], ErrMissingRequiredOption,
+					fmt.Sprintf("requires one of %s, %s", strings.Join(rule.Others, ", "), rule.Message))
+			}
+		case ImpliesWarning:
+			if len(setOthers) > 0 {
+				warnings = append(warnings, OptionWarning{Option: rule.Option, Message: rule.Message, Code: rule.Code})
+			}
+		}
+	}
+	return warnings, validationErrors
+}
+
+// isOptionSet returns whether an option has a value, a false value of a BoolOption is considered as not set.
+func isOptionSet(options map[string]string, option string) bool {
+	value := strings.TrimSpace(options[option])
+	if value == "" {
+		return false
+	}
+	for _, spec := range GeneralOptionSpecs {
+		if spec.Name == option && spec.Type == BoolOption {
+			enabled, err := strconv.ParseBool(value)
+			return err != nil || enabled
+		}
+	}
+	return true
+}
+
+// MaxRegexAlternatives is the number of alternatives in a regex above which the regex is linted as too large.
+const MaxRegexAlternatives = 50
+
+// statusRegexOptions are the regex options matched against the status label of the metric profile.
+var statusRegexOptions = map[string]bool{
+	"good_http_status_regex": true, "bad_http_status_regex": true, "exclude_from_total_http_status_regex": true,
+}
+
+// LintRegexes returns warnings for regexes that are valid but most likely not doing what is intended,
+// keeping in mind that Prometheus fully anchors regex matchers.
+// When `strict_regex_lint` is true, the findings are returned as ValidationErrors instead.
+// Invalid regexes are left to ValidateGeneralExpCommonFilterOptions.
+func LintRegexes(options map[string]string) ([]OptionWarning, error) {
+	warnings, validationErrors := lintRegexes(options)
+	return warnings, validationErrors.ErrorOrNil()
+}
+
+func lintRegexes(options map[string]string) ([]OptionWarning, ValidationErrors) {
+	strict, err := getBoolOption(options, "strict_regex_lint", false)
+	if err != nil {
+		return nil, nil
+	}
+
+	profile := FilterOptions{MetricProfile: options["metric_profile"]}.Profile()
+	var warnings []OptionWarning
+	var validationErrors ValidationErrors
+	for _, spec := range GeneralOptionSpecs {
+		option, value := spec.Name, options[spec.Name]
+		if spec.Type != RegexOption || value == "" {
+			continue
+		}
+		var statusValues []string
+		if statusRegexOptions[option] {
+			statusValues = profile.StatusValues
+		}
+		for _, message := range lintRegex(value, statusValues, profile.StatusDomain) {
+			if strict {
+				validationErrors.Add(option, value, ErrRegexLint, message)
+			} else {
+				warnings = append(warnings, OptionWarning{Option: option, Message: message, Code: AdvisoryRegexLint})
+			}
+		}
+	}
+	return warnings, validationErrors
+}
+
+// lintRegex returns the lint findings of a single regex, status regexes have to match one of the statusValues.
+func lintRegex(value string, statusValues []string, statusDomain string) []string {
+	parsed, err := syntax.Parse(value, syntax.Perl)
+	if err != nil {
+		return nil
+	}
+	anchored, err := regexp.Compile("^(?:" + value + ")$")
+	if err != nil {
+		return nil
+	}
+
+	var messages []string
+	if strings.HasPrefix(value, "^") ||
+		(strings.HasSuffix(value, "$") && !strings.HasSuffix(value, `\$`)) {
+		messages = append(messages, "has redundant anchors, Prometheus fully anchors regex matchers")
+	}
+	if strings.Contains(value, `\\`) {
+		messages = append(messages, `has a doubled backslash, which matches a literal backslash since regex options `+
+			`are escaped by the plugin, write \. instead of \\.`)
+	}
+
+	simplified := parsed.Simplify()
+	switch {
+	case simplified.Op == syntax.OpNoMatch || (simplified.Op == syntax.OpCharClass && len(simplified.Rune) == 0):
+		messages = append(messages, "matches nothing")
+	case simplified.Op == syntax.OpStar && len(simplified.Sub) == 1 &&
+		(simplified.Sub[0].Op == syntax.OpAnyChar || simplified.Sub[0].Op == syntax.OpAnyCharNotNL):
+		messages = append(messages, "matches everything, leave the option unset instead")
+	case len(statusValues) > 0 && !matchesAny(anchored, statusValues):
+		messages = append(messages, "can never match a "+statusDomain)
+	}
+
+	if alternatives := countAlternatives(value); alternatives > MaxRegexAlternatives {
+		messages = append(messages, fmt.Sprintf("has %d alternatives, more than %d make queries slow and hard to read",
+			alternatives, MaxRegexAlternatives))
+	}
+	return messages
+}
+
+// matchesAny returns whether the anchored regex matches any of the values.
+func matchesAny(anchored *regexp.Regexp, values []string) bool {
+	for _, value := range values {
+		if anchored.MatchString(value) {
+			return true
+		}
+	}
+	return false
+}
+
+// countAlternatives returns the number of alternatives of a regex,
+// counting every `|` outside of character classes, including the ones of nested groups.
+func countAlternatives(value string) int {
+	alternatives := 1
+	inClass := false
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; {
+		case c == '\\':
+			i++
+		case c == '[':
+			inClass = true
+		case c == ']':
+			inClass = false
+		case c == '|' && !inClass:
+			alternatives++
+		}
+	}
+	return alternatives
+}
+
+// OptionSpec is the machine-readable description of an option.
+// Pattern is a regex the value has to match, Minimum and Maximum bound IntOption values when Maximum is not 0.
+type OptionSpec struct {
+	Name          string
+	Type          OptionType
+	Mandatory     bool
+	Default       string
+	AllowedValues []string
+	Pattern       string
+	Minimum       int
+	Maximum       int
+	Description   string
+	Example       string
+}
+
+// GeneralOptionSpecs describe the options supported by all plugins using the general and success filters.
+var GeneralOptionSpecs = []OptionSpec{
+	{Name: "metric_profile", Type: StringOption, Default: DefaultMetricProfile, AllowedValues: MetricProfileNames(),
+		Description: "the metric profile: the request metric, its transaction and status labels (`APM_TRANSACTION` " +
+			"and `RESPONSE_STATUS` by default), the job suffix, the default good status regex and the health checks, " +
+			"the transaction options (`apm_tx...`) and the status options (`..._status_regex`) apply to its labels",
+		Example: DefaultMetricProfile},
+	{Name: "servicename", Type: StringOption, Pattern: serviceNamePattern,
+		Description: "used to filter Prometheus jobs by appending `job_suffix`, " +
+			"e.g. `demandproduct` as `demandproduct-metrics`, defaults to the `service` of the sloth SLO spec, " +
+			"not needed with `job` or `job_regex`",
+		Example: "demandproduct"},
+	{Name: "job", Type: StringOption,
+		Description: "the exact Prometheus job, instead of the job derived from `servicename`, " +
+			"e.g. for services scraped by a PodMonitor",
+		Example: "demandproduct/demandproduct-pods"},
+	{Name: "job_regex", Type: RegexOption,
+		Description: "the Prometheus jobs as a regex, instead of the job derived from `servicename`, " +
+			"e.g. for a service scraped in several clusters",
+		Example: "demandproduct-(metrics|canary)"},
+	{Name: "job_suffix", Type: StringOption, Default: DefaultJobSuffix, Pattern: jobSuffixPattern,
+		Description: "appended to `servicename` for the Prometheus job, defaults to the job suffix of the metric profile",
+		Example:     "-pods"},
+	{Name: "apm_tx", Type: ListOption,
+		Description: "the transaction to look at, or a comma separated list of them",
+		Example:     "/product/full"},
+	{Name: "apm_tx_glob", Type: ListOption,
+		Description: "comma separated transaction glob patterns to look at, `*` matches within a path segment, " +
+			"`**` across path segments and `?` a single character",
+		Example: "/product/*"},
+	{Name: "apm_tx_regex", Type: RegexOption,
+		Description: "the transaction to look at as a regex",
+		Example:     "/product/(full|lite)"},
+	{Name: "apm_tx_case_insensitive", Type: BoolOption, Default: "false",
+		Description: "matches `apm_tx`, `apm_tx_glob` and `apm_tx_regex` case-insensitively, " +
+			"`apm_tx` is then rendered as a regex",
+		Example: "true"},
+	{Name: "apm_tx_exclude", Type: StringOption,
+		Description: "the transaction to ignore",
+		Example:     "/product/preview"},
+	{Name: "apm_tx_exclude_regex", Type: RegexOption,
+		Description: "the transactions to ignore as a regex",
+		Example:     "/internal/.*"},
+	{Name: "filter", Type: FilterOption,
+		Description: "PromQL label matchers used for total and success queries",
+		Example:     `CLIENT="TRIPADVISOR"`},
+	{Name: "filter_from_labels", Type: ListOption, Pattern: labelNameListPattern,
+		Description: "comma separated labels of the spec added to `filter` as exact matchers with the label values " +
+			"of the spec, e.g. `env` as `env=\"prod\"` for a spec labeled `env: prod`",
+		Example: "env,region"},
+	{Name: "success_filter", Type: FilterOption,
+		Description: "PromQL label matchers used for success queries, a blank value prevents the default good status regex",
+		Example:     `RESULT="SUCCESS"`},
+	{Name: "good_http_status_regex", Type: RegexOption,
+		Description: "a regex of the status codes of successful/good responses, the availability plugin defaults to " +
+			"the good status regex of the metric profile (`2..` by default) if neither `success_filter` nor " +
+			"`bad_http_status_regex` are set",
+		Example: "[23].."},
+	{Name: "bad_http_status_regex", Type: RegexOption,
+		Description: "a regex of the status codes of bad responses",
+		Example:     "5.."},
+	{Name: "exclude_from_total_http_status_regex", Type: RegexOption,
+		Description: "a regex of the status codes removed from the total as well as the successful response query, " +
+			"e.g. `4..` to not count client errors against the SLO",
+		Example: "4.."},
+	{Name: "exclude_filter", Type: FilterOption,
+		Description: "PromQL label matchers of requests removed from the total as well as the successful response query, " +
+			"unlike `filter` every matcher removes the requests it matches on its own, " +
+			"e.g. `CLIENT=\"bot\", REGION=\"eu\"` removes all bot and all eu requests",
+		Example: `CLIENT="MONITORING"`},
+	{Name: "exclude_health_checks", Type: BoolOption, Default: "true",
+		Description: "excludes health and readiness transactions (e.g. `/ping`) from the total as well as " +
+			"the successful response query, ignored when `apm_tx` is set",
+		Example: "false"},
+	{Name: "health_check_apm_tx_regex", Type: RegexOption, Default: DefaultHealthCheckApmTxRegex,
+		Description: "a regex of the health check transactions to exclude, defaults to the health checks of the " +
+			"metric profile",
+		Example: "/ping|/status"},
+	{Name: "allow_unknown_options", Type: BoolOption, Default: "false",
+		Description: "accepts unknown options for forward compatibility, otherwise unknown or misspelled options " +
+			"are rejected with a suggestion of the closest valid option",
+		Example: "true"},
+	{Name: "strict_regex_lint", Type: BoolOption, Default: "false",
+		Description: "rejects regexes with lint findings instead of only warning about them",
+		Example:     "true"},
+	{Name: "trace_header", Type: BoolOption, Default: "true",
+		Description: "prepends a PromQL comment header with the plugin ID, options hash and notes to the query, " +
+			"tracing the recording rules generated by sloth back to the plugin and options that produced them, " +
+			"Prometheus drops the comments when loading the rules",
+		Example: "false"},
+}
+
+// GeneralOptions are the options supported by all plugins using the general and success filters.
+var GeneralOptions = OptionNames(GeneralOptionSpecs)
+
+// UsageExample is a documented example of the plugin options.
+type UsageExample struct {
+	Title       string
+	Description string
+	Options     map[string]string
+}
+
+// OptionNames returns the names of the options of a schema.
+func OptionNames(schema []OptionSpec) []string {
+	names := make([]string, 0, len(schema))
+	for _, spec := range schema {
+		names = append(names, spec.Name)
+	}
+	return names
+}
+
+// ValidateOptionSchema returns ValidationErrors for values not matching the type or the allowed values of their option.
+// Mandatory options, patterns and ranges are validated by the dedicated functions, e.g. GetServiceName.
+func ValidateOptionSchema(options map[string]string, schema []OptionSpec) error {
+	return validateOptionSchema(options, schema).ErrorOrNil()
+}
+
+func validateOptionSchema(options map[string]string, schema []OptionSpec) ValidationErrors {
+	var validationErrors ValidationErrors
+	for _, spec := range schema {
+		value := options[spec.Name]
+		if strings.TrimSpace(value) == "" {
+			continue
+		}
+
+		switch spec.Type {
+		case RegexOption:
+			if _, err := regexp.Compile(value); err != nil {
+				validationErrors.Add(spec.Name, value, ErrInvalidRegex, err.Error())
+			}
+		case FilterOption:
+			if _, err := ParseMatchers(value); err != nil {
+				validationErrors.Add(spec.Name, value, ErrInvalidFilter, err.Error())
+			}
+		case BoolOption:
+			_, err := getBoolOption(options, spec.Name, false)
+			validationErrors.Append(err)
+		}
+
+		if len(spec.AllowedValues) > 0 && !containsString(spec.AllowedValues, strings.TrimSpace(value)) {
+			validationErrors.Add(spec.Name, value, ErrInvalidValue,
+				fmt.Sprintf("needs to be one of %s", strings.Join(spec.AllowedValues, ", ")))
+		}
+	}
+	return validationErrors
+}
+
+// containsString returns whether the value is one of the values.
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateKnownOptions returns ValidationErrors for every option that is not known, suggesting the closest known option.
+// Unknown options are accepted for forward compatibility when `allow_unknown_options` is true.
+func ValidateKnownOptions(options map[string]string, knownOptions []string) error {
+	return validateKnownOptions(options, knownOptions).ErrorOrNil()
+}
+
+func validateKnownOptions(options map[string]string, knownOptions []string) ValidationErrors {
+	// an invalid `allow_unknown_options` is reported by ValidateOptionSchema
+	if allowUnknownOptions, err := getBoolOption(options, "allow_unknown_options", false); err == nil && allowUnknownOptions {
+		return nil
+	}
+
+	known := map[string]bool{}
+	for _, option := range knownOptions {
+		known[option] = true
+	}
+
+	var unknown []string
+	for option := range options {
+		if !known[option] {
+			unknown = append(unknown, option)
+		}
+	}
+	sort.Strings(unknown)
+
+	var validationErrors ValidationErrors
+	for _, option := range unknown {
+		reason := ""
+		if suggestion := closestOption(option, knownOptions); suggestion != "" {
+			reason = fmt.Sprintf("did you mean '%s'?", suggestion)
+		}
+		validationErrors.Add(option, "", ErrUnknownOption, reason)
+	}
+
+	return validationErrors
+}
+
+// closestOption returns the known option containing the given option or with the smallest edit distance,
+// as long as the distance is small enough to be a likely misspelling.
+func closestOption(option string, knownOptions []string) string {
+	closest := ""
+	closestDistance := len(option)/3 + 1
+	for _, known := range knownOptions {
+		if len(option) > 3 && strings.Contains(known, option) {
+			return known
+		}
+		if distance := editDistance(option, known); distance < closestDistance {
+			closest, closestDistance = known, distance
+		}
+	}
+	return closest
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a string, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min3(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
+
+func min3(a int, b int, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+// FilterOptions are the typed general options of the plugins using the general and success filters.
+// The zero value includes health checks and omits the trace header,
+// ParseFilterOptions and NewQueryBuilder apply the option defaults.
+type FilterOptions struct {
+	// MetricProfile is the name of the metric profile, DefaultMetricProfile when empty.
+	MetricProfile string
+	ServiceName   string
+	// Job and JobRegex replace the job derived from ServiceName and JobSuffix, DefaultJobSuffix when empty.
+	Job                  string
+	JobRegex             string
+	JobSuffix            string
+	ApmTx                []string
+	ApmTxGlob            []string
+	ApmTxRegex           string
+	ApmTxCaseInsensitive bool
+	ApmTxExclude         string
+	ApmTxExcludeRegex    string
+	Filter               []LabelMatcher
+	// SuccessFilter prevents the default good status regex when it is not nil, even without matchers.
+	SuccessFilter                   []LabelMatcher
+	GoodHTTPStatusRegex             string
+	BadHTTPStatusRegex              string
+	ExcludeFromTotalHTTPStatusRegex string
+	ExcludeFilter                   []LabelMatcher
+	ExcludeHealthChecks             bool
+	HealthCheckApmTxRegex           string
+	AllowUnknownOptions             bool
+	StrictRegexLint                 bool
+	TraceHeader                     bool
+}
+
+// ParseFilterOptions returns the typed general options and ValidationErrors for values that can not be parsed.
+// The values are not validated any further, see ValidateGeneralExpCommonFilterOptions.
+func ParseFilterOptions(options map[string]string) (FilterOptions, error) {
+	var validationErrors ValidationErrors
+	serviceName, err := getServiceName(options)
+	if err != nil && !(err.Err == ErrMissingMandatory && hasJobOption(options)) {
+		validationErrors.Append(err)
+	}
+
+	parsed := FilterOptions{
+		MetricProfile:                   strings.TrimSpace(options["metric_profile"]),
+		ServiceName:                     serviceName,
+		Job:                             strings.TrimSpace(options["job"]),
+		JobRegex:                        options["job_regex"],
+		JobSuffix:                       strings.TrimSpace(options["job_suffix"]),
+		ApmTx:                           SplitOptionList(options["apm_tx"]),
+		ApmTxGlob:                       SplitOptionList(options["apm_tx_glob"]),
+		ApmTxRegex:                      options["apm_tx_regex"],
+		ApmTxExclude:                    options["apm_tx_exclude"],
+		ApmTxExcludeRegex:               options["apm_tx_exclude_regex"],
+		GoodHTTPStatusRegex:             options["good_http_status_regex"],
+		BadHTTPStatusRegex:              options["bad_http_status_regex"],
+		ExcludeFromTotalHTTPStatusRegex: options["exclude_from_total_http_status_regex"],
+		HealthCheckApmTxRegex:           options["health_check_apm_tx_regex"],
+	}
+
+	for _, filter := range []struct {
+		matchers *[]LabelMatcher
+		option   string
+	}{
+		{&parsed.Filter, "filter"}, {&parsed.SuccessFilter, "success_filter"}, {&parsed.ExcludeFilter, "exclude_filter"},
+	} {
+		matchers, err := ParseMatchers(options[filter.option])
+		if err != nil {
+			validationErrors.Add(filter.option, options[filter.option], ErrInvalidFilter, err.Error())
+		}
+		*filter.matchers = matchers
+	}
+	if parsed.SuccessFilter == nil && options["success_filter"] != "" {
+		parsed.SuccessFilter = []LabelMatcher{}
+	}
+
+	for _, flag := range []struct {
+		value        *bool
+		option       string
+		defaultValue bool
+	}{
+		{&parsed.ApmTxCaseInsensitive, "apm_tx_case_insensitive", false},
+		{&parsed.ExcludeHealthChecks, "exclude_health_checks", true},
+		{&parsed.AllowUnknownOptions, "allow_unknown_options", false},
+		{&parsed.StrictRegexLint, "strict_regex_lint", false},
+		{&parsed.TraceHeader, "trace_header", true},
+	} {
+		value, err := getBoolOption(options, flag.option, flag.defaultValue)
+		validationErrors.Append(err)
+		*flag.value = value
+	}
+
+	return parsed, validationErrors.ErrorOrNil()
+}
+
+// Map returns the general options as sloth plugin options, leaving out empty and default values.
+func (o FilterOptions) Map() map[string]string {
+	options := map[string]string{}
+	for option, value := range map[string]string{
+		"servicename":                          o.ServiceName,
+		"job":                                  o.Job,
+		"job_regex":                            o.JobRegex,
+		"apm_tx":                               strings.Join(o.ApmTx, ","),
+		"apm_tx_glob":                          strings.Join(o.ApmTxGlob, ","),
+		"apm_tx_regex":                         o.ApmTxRegex,
+		"apm_tx_exclude":                       o.ApmTxExclude,
+		"apm_tx_exclude_regex":                 o.ApmTxExcludeRegex,
+		"filter":                               FormatMatchers(o.Filter),
+		"success_filter":                       FormatMatchers(o.SuccessFilter),
+		"good_http_status_regex":               o.GoodHTTPStatusRegex,
+		"bad_http_status_regex":                o.BadHTTPStatusRegex,
+		"exclude_from_total_http_status_regex": o.ExcludeFromTotalHTTPStatusRegex,
+		"exclude_filter":                       FormatMatchers(o.ExcludeFilter),
+		"health_check_apm_tx_regex":            o.HealthCheckApmTxRegex,
+	} {
+		if value != "" {
+			options[option] = value
+		}
+	}
+
+	if o.MetricProfile != "" && o.MetricProfile != DefaultMetricProfile {
+		options["metric_profile"] = o.MetricProfile
+	}
+	if o.JobSuffix != "" && o.JobSuffix != o.Profile().JobSuffix {
+		options["job_suffix"] = o.JobSuffix
+	}
+	if o.SuccessFilter != nil && len(o.SuccessFilter) == 0 {
+		// a blank success filter prevents the default good status regex
+		options["success_filter"] = " "
+	}
+	if o.ApmTxCaseInsensitive {
+		options["apm_tx_case_insensitive"] = "true"
+	}
+	if !o.ExcludeHealthChecks {
+		options["exclude_health_checks"] = "false"
+	}
+	if o.AllowUnknownOptions {
+		options["allow_unknown_options"] = "true"
+	}
+	if o.StrictRegexLint {
+		options["strict_regex_lint"] = "true"
+	}
+	if !o.TraceHeader {
+		options["trace_header"] = "false"
+	}
+	return options
+}
+
+// GeneralMatchers returns the label matchers for all requests, used for total and success queries.
+// Requests matching `exclude_from_total_http_status_regex` or any matcher of `exclude_filter` are removed from both.
+func (o FilterOptions) GeneralMatchers() []LabelMatcher {
+	apmTx, apmTxListRegex := o.apmTxMatchers()
+
+	profile := o.Profile()
+
+	matchers := []LabelMatcher{o.jobMatcher()}
+	matchers = appendMatcher(matchers, profile.TransactionLabel, "=", apmTx)
+	matchers = appendMatcher(matchers, profile.TransactionLabel, "=~", apmTxListRegex)
+	matchers = appendMatcher(matchers, profile.TransactionLabel, "=~", o.apmTxRegex())
+	matchers = appendMatcher(matchers, profile.TransactionLabel, "!=", o.ApmTxExclude)
+	matchers = appendMatcher(matchers, profile.TransactionLabel, "!~", o.ApmTxExcludeRegex)
+	matchers = append(matchers, o.Filter...)
+	matchers = appendMatcher(matchers, profile.StatusLabel, "!~", o.ExcludeFromTotalHTTPStatusRegex)
+	matchers = append(matchers, InvertMatchers(o.ExcludeFilter)...)
+	matchers = appendMatcher(matchers, profile.TransactionLabel, "!~", o.healthCheckApmTxRegex())
+
+	return matchers
+}
+
+// jobMatcher returns the matcher of the job, `job` and `job_regex` take precedence over the job derived from
+// `servicename` and `job_suffix` or the job suffix of the metric profile.
+func (o FilterOptions) jobMatcher() LabelMatcher {
+	switch {
+	case o.Job != "":
+		return LabelMatcher{Name: "job", Op: "=", Value: o.Job}
+	case o.JobRegex != "":
+		return LabelMatcher{Name: "job", Op: "=~", Value: o.JobRegex}
+	case o.JobSuffix != "":
+		return LabelMatcher{Name: "job", Op: "=", Value: o.ServiceName + o.JobSuffix}
+	}
+	return LabelMatcher{Name: "job", Op: "=", Value: o.ServiceName + o.Profile().JobSuffix}
+}
+
+// Profile returns the metric profile of the options, the default profile when it is unknown.
+func (o FilterOptions) Profile() MetricProfile {
+	if profile, ok := GetMetricProfile(o.MetricProfile); ok {
+		return profile
+	}
+	profile, _ := GetMetricProfile(DefaultMetricProfile)
+	return profile
+}
+
+// SuccessMatchers returns the label matchers for successful requests.
+// when `enforceSuccessFilter` is true, the good status regex of the profile will be used if nothing else is set.
+func (o FilterOptions) SuccessMatchers(enforceSuccessFilter bool) []LabelMatcher {
+	goodHTTPStatusRegex, badHTTPStatusRegex := o.StatusRegexes(enforceSuccessFilter)
+
+	var matchers []LabelMatcher
+	matchers = appendMatcher(matchers, o.Profile().StatusLabel, "=~", goodHTTPStatusRegex)
+	matchers = appendMatcher(matchers, o.Profile().StatusLabel, "!~", badHTTPStatusRegex)
+
+	return append(matchers, o.SuccessFilter...)
+}
+
+// StatusRegexes returns the good and bad status regexes.
+// when `enforceSuccessFilter` is true, the good status regex of the profile will be returned if nothing else is set.
+func (o FilterOptions) StatusRegexes(enforceSuccessFilter bool) (goodHTTPStatusRegex string,
+	badHTTPStatusRegex string) {
+	goodHTTPStatusRegex = o.GoodHTTPStatusRegex
+	badHTTPStatusRegex = o.BadHTTPStatusRegex
+
+	if enforceSuccessFilter && (o.SuccessFilter == nil && goodHTTPStatusRegex == "" && badHTTPStatusRegex == "") {
+		goodHTTPStatusRegex = o.Profile().GoodStatusRegex
+	}
+	return goodHTTPStatusRegex, badHTTPStatusRegex
+}
+
+// explainNoRequests explains the result of the error ratio without requests, see ErrorRatio.
+const explainNoRequests = "without requests in the window the SLI reports no errors"
+
+// ExplainRequests returns a human-readable description of the requests selected by the general matchers,
+// e.g. `requests to demandproduct-metrics, APM_TRANSACTION=/product/full`.
+func (o FilterOptions) ExplainRequests() string {
+	job := o.jobMatcher()
+	parts := []string{"requests to " + job.Value}
+	if job.Op == "=~" {
+		parts[0] = "requests to jobs matching " + job.Value
+	}
+
+	transactionLabel := o.Profile().TransactionLabel
+	apmTx, apmTxListRegex := o.apmTxMatchers()
+	if apmTx != "" {
+		parts = append(parts, transactionLabel+"="+apmTx)
+	}
+	for _, regex := range []string{apmTxListRegex, o.apmTxRegex()} {
+		if regex != "" {
+			parts = append(parts, transactionLabel+" matching "+regex)
+		}
+	}
+	if o.ApmTxExclude != "" {
+		parts = append(parts, transactionLabel+" other than "+o.ApmTxExclude)
+	}
+	if o.ApmTxExcludeRegex != "" {
+		parts = append(parts, transactionLabel+" not matching "+o.ApmTxExcludeRegex)
+	}
+	for _, matcher := range o.Filter {
+		parts = append(parts, matcher.String())
+	}
+
+	if o.ExcludeFromTotalHTTPStatusRegex != "" {
+		codes, _ := GetGoodStatusCodes(map[string]string{"metric_profile": o.MetricProfile,
+			"good_http_status_regex": o.ExcludeFromTotalHTTPStatusRegex}, false)
+		parts = append(parts, "excluding "+DescribeStatusCodes(codes))
+	}
+	if len(o.ExcludeFilter) > 0 {
+		var excluded []string
+		for _, matcher := range o.ExcludeFilter {
+			excluded = append(excluded, matcher.String())
+		}
+		parts = append(parts, "excluding requests with "+strings.Join(excluded, " or "))
+	}
+	switch healthCheckApmTxRegex := o.healthCheckApmTxRegex(); healthCheckApmTxRegex {
+	case "":
+	case o.Profile().HealthCheckRegex:
+		parts = append(parts, "excluding health checks")
+	default:
+		parts = append(parts, "excluding health checks matching "+healthCheckApmTxRegex)
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+// ExplainSuccess returns a human-readable list of the good status values and success matchers, e.g. `good: 2xx, 404`.
+// It is empty when every request is successful.
+func (o FilterOptions) ExplainSuccess(enforceSuccessFilter bool) string {
+	var parts []string
+	if goodHTTPStatusRegex, badHTTPStatusRegex := o.StatusRegexes(enforceSuccessFilter); goodHTTPStatusRegex != "" ||
+		badHTTPStatusRegex != "" {
+		codes, _ := GetGoodStatusCodes(o.Map(), enforceSuccessFilter)
+		parts = append(parts, DescribeStatusCodes(codes))
+	}
+	for _, matcher := range o.SuccessFilter {
+		parts = append(parts, matcher.String())
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return "good: " + strings.Join(parts, ", ")
+}
+
+// QueryBuilder builds the query of the plugin from typed options, e.g. for tooling generating SLIs without option maps.
+// It relies on the Options of the plugin embedding FilterOptions.
+type QueryBuilder struct {
+	options Options
+}
+
+// NewQueryBuilder returns a QueryBuilder for the service with the default options.
+func NewQueryBuilder(serviceName string) *QueryBuilder {
+	builder := &QueryBuilder{}
+	builder.options.ServiceName = serviceName
+	builder.options.ExcludeHealthChecks = true
+	builder.options.TraceHeader = true
+	return builder
+}
+
+// MetricProfile sets the name of the metric profile of `metric_profile`.
+func (b *QueryBuilder) MetricProfile(name string) *QueryBuilder {
+	b.options.MetricProfile = name
+	return b
+}
+
+// Job sets the exact job of `job`.
+func (b *QueryBuilder) Job(job string) *QueryBuilder {
+	b.options.Job = job
+	return b
+}
+
+// JobRegex sets the job regex of `job_regex`.
+func (b *QueryBuilder) JobRegex(regex string) *QueryBuilder {
+	b.options.JobRegex = regex
+	return b
+}
+
+// JobSuffix sets the suffix of `job_suffix` appended to the service name for the job.
+func (b *QueryBuilder) JobSuffix(suffix string) *QueryBuilder {
+	b.options.JobSuffix = suffix
+	return b
+}
+
+// ApmTx sets the exact transactions of `apm_tx`.
+func (b *QueryBuilder) ApmTx(transactions ...string) *QueryBuilder {
+	b.options.ApmTx = append([]string{}, transactions...)
+	return b
+}
+
+// ApmTxGlob sets the transaction glob patterns of `apm_tx_glob`.
+func (b *QueryBuilder) ApmTxGlob(globs ...string) *QueryBuilder {
+	b.options.ApmTxGlob = append([]string{}, globs...)
+	return b
+}
+
+// ApmTxRegex sets the transaction regex of `apm_tx_regex`.
+func (b *QueryBuilder) ApmTxRegex(regex string) *QueryBuilder {
+	b.options.ApmTxRegex = regex
+	return b
+}
+
+// ApmTxCaseInsensitive sets whether the transactions are matched case-insensitively.
+func (b *QueryBuilder) ApmTxCaseInsensitive(caseInsensitive bool) *QueryBuilder {
+	b.options.ApmTxCaseInsensitive = caseInsensitive
+	return b
+}
+
+// ApmTxExclude sets the transaction to ignore of `apm_tx_exclude`.
+func (b *QueryBuilder) ApmTxExclude(transaction string) *QueryBuilder {
+	b.options.ApmTxExclude = transaction
+	return b
+}
+
+// ApmTxExcludeRegex sets the regex of the transactions to ignore of `apm_tx_exclude_regex`.
+func (b *QueryBuilder) ApmTxExcludeRegex(regex string) *QueryBuilder {
+	b.options.ApmTxExcludeRegex = regex
+	return b
+}
+
+// Filter sets the label matchers of `filter`.
+func (b *QueryBuilder) Filter(matchers ...LabelMatcher) *QueryBuilder {
+	b.options.Filter = append([]LabelMatcher{}, matchers...)
+	return b
+}
+
+// SuccessFilter sets the label matchers of `success_filter`, preventing the default good status regex.
+func (b *QueryBuilder) SuccessFilter(matchers ...LabelMatcher) *QueryBuilder {
+	b.options.SuccessFilter = append([]LabelMatcher{}, matchers...)
+	return b
+}
+
+// GoodHTTPStatusRegex sets the regex of `good_http_status_regex`.
+func (b *QueryBuilder) GoodHTTPStatusRegex(regex string) *QueryBuilder {
+	b.options.GoodHTTPStatusRegex = regex
+	return b
+}
+
+// BadHTTPStatusRegex sets the regex of `bad_http_status_regex`.
+func (b *QueryBuilder) BadHTTPStatusRegex(regex string) *QueryBuilder {
+	b.options.BadHTTPStatusRegex = regex
+	return b
+}
+
+// ExcludeFromTotalHTTPStatusRegex sets the regex of `exclude_from_total_http_status_regex`.
+func (b *QueryBuilder) ExcludeFromTotalHTTPStatusRegex(regex string) *QueryBuilder {
+	b.options.ExcludeFromTotalHTTPStatusRegex = regex
+	return b
+}
+
+// ExcludeFilter sets the label matchers of `exclude_filter`.
+func (b *QueryBuilder) ExcludeFilter(matchers ...LabelMatcher) *QueryBuilder {
+	b.options.ExcludeFilter = append([]LabelMatcher{}, matchers...)
+	return b
+}
+
+// ExcludeHealthChecks sets whether health check transactions are excluded, the default is true.
+func (b *QueryBuilder) ExcludeHealthChecks(exclude bool) *QueryBuilder {
+	b.options.ExcludeHealthChecks = exclude
+	return b
+}
+
+// HealthCheckApmTxRegex sets the regex of the health check transactions of `health_check_apm_tx_regex`.
+func (b *QueryBuilder) HealthCheckApmTxRegex(regex string) *QueryBuilder {
+	b.options.HealthCheckApmTxRegex = regex
+	return b
+}
+
+// TraceHeader sets whether the query starts with the trace header, the default is true.
+func (b *QueryBuilder) TraceHeader(traceHeader bool) *QueryBuilder {
+	b.options.TraceHeader = traceHeader
+	return b
+}
+
+// Options returns the typed options built so far.
+func (b *QueryBuilder) Options() Options {
+	return b.options
+}
+
+// Build validates the options and returns the query.
+func (b *QueryBuilder) Build() (string, error) {
+	return b.options.Query()
+}
+
+// GetGeneralExpCommonMatchers returns the label matchers for all requests, see FilterOptions.GeneralMatchers.
+func GetGeneralExpCommonMatchers(options map[string]string) ([]LabelMatcher, error) {
+	parsed, err := ParseFilterOptions(options)
+	if err != nil {
+		return nil, err
+	}
+	return parsed.GeneralMatchers(), nil
+}
+
+// appendMatcher appends a label matcher for the value, unless the value is empty.
+func appendMatcher(matchers []LabelMatcher, name string, op string, value string) []LabelMatcher {
+	if value == "" {
+		return matchers
+	}
+	return append(matchers, LabelMatcher{Name: name, Op: op, Value: value})
+}
+
+// GetApmTxMatchers returns either the single exact transaction of `apm_tx`
+// or a regex combining the comma separated `apm_tx` transactions and `apm_tx_glob` patterns.
+// The regex is escaped and relies on Prometheus anchoring regex matchers to match whole transactions only.
+// With `apm_tx_case_insensitive` the transactions are always returned as a case-insensitive regex.
+func GetApmTxMatchers(options map[string]string) (apmTx string, apmTxRegex string, err error) {
+	caseInsensitive, err := GetBoolOption(options, "apm_tx_case_insensitive", false)
+	if err != nil {
+		return "", "", err
+	}
+
+	apmTx, apmTxRegex = FilterOptions{ApmTx: SplitOptionList(options["apm_tx"]),
+		ApmTxGlob: SplitOptionList(options["apm_tx_glob"]), ApmTxCaseInsensitive: caseInsensitive}.apmTxMatchers()
+	return apmTx, apmTxRegex, nil
+}
+
+func (o FilterOptions) apmTxMatchers() (apmTx string, apmTxRegex string) {
+	if len(o.ApmTx) == 0 && len(o.ApmTxGlob) == 0 {
+		return "", ""
+	}
+
+	if len(o.ApmTx) == 1 && len(o.ApmTxGlob) == 0 && !o.ApmTxCaseInsensitive {
+		return o.ApmTx[0], ""
+	}
+
+	var patterns []string
+	for _, transaction := range o.ApmTx {
+		patterns = append(patterns, regexp.QuoteMeta(transaction))
+	}
+	for _, glob := range o.ApmTxGlob {
+		patterns = append(patterns, GlobToRegex(glob))
+	}
+
+	apmTxRegex = strings.Join(patterns, "|")
+	if o.ApmTxCaseInsensitive {
+		apmTxRegex = "(?i)" + apmTxRegex
+	}
+	return "", apmTxRegex
+}
+
+// GetApmTxRegex returns the `apm_tx_regex`, made case-insensitive with `apm_tx_case_insensitive`.
+func GetApmTxRegex(options map[string]string) (string, error) {
+	caseInsensitive, err := GetBoolOption(options, "apm_tx_case_insensitive", false)
+	if err != nil {
+		return "", err
+	}
+	return FilterOptions{ApmTxRegex: options["apm_tx_regex"], ApmTxCaseInsensitive: caseInsensitive}.apmTxRegex(), nil
+}
+
+func (o FilterOptions) apmTxRegex() string {
+	if o.ApmTxCaseInsensitive && o.ApmTxRegex != "" {
+		return "(?i)" + o.ApmTxRegex
+	}
+	return o.ApmTxRegex
+}
+
+// GetBoolOption returns the boolean value of an option or the default value if it is not set.
+func GetBoolOption(options map[string]string, option string, defaultValue bool) (bool, error) {
+	result, err := getBoolOption(options, option, defaultValue)
+	if err != nil {
+		return false, err
+	}
+	return result, nil
+}
+
+// getBoolOption is GetBoolOption returning the typed *OptionError for ValidationErrors.Append.
+func getBoolOption(options map[string]string, option string, defaultValue bool) (bool, *OptionError) {
+	value := strings.TrimSpace(options[option])
+	if value == "" {
+		return defaultValue, nil
+	}
+
+	result, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, &OptionError{Option: option, Value: value, Err: ErrInvalidValue, Reason: "needs to be a boolean"}
+	}
+	return result, nil
+}
+
+// GlobToRegex converts a transaction glob pattern into a regex.
+// `*` matches within a path segment, `**` matches across segments and `?` matches a single character.
+func GlobToRegex(glob string) string {
+	var regex strings.Builder
+	for i := 0; i < len(glob); i++ {
+		switch {
+		case strings.HasPrefix(glob[i:], "**"):
+			regex.WriteString(".*")
+			i++
+		case glob[i] == '*':
+			regex.WriteString("[^/]*")
+		case glob[i] == '?':
+			regex.WriteString("[^/]")
+		default:
+			j := i + 1
+			for j < len(glob) && glob[j] != '*' && glob[j] != '?' {
+				j++
+			}
+			regex.WriteString(regexp.QuoteMeta(glob[i:j]))
+			i = j - 1
+		}
 	}
+	return regex.String()
+}
 
-	return servicename, nil
+// SplitOptionList splits a comma separated option value, ignoring empty entries.
+func SplitOptionList(value string) []string {
+	var values []string
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
 }
 
-func ValidateGeneralExpCommonFilterOptions(options map[string]string) error {
-	errors := ""
-	servicename := strings.TrimSpace(options["servicename"])
-	if servicename == "" {
-		errors += "servicename is mandatory."
+// EscapeLabelValue escapes a value to be used within a double-quoted PromQL label matcher,
+// so that quotes and backslashes can neither break the query nor inject additional matchers.
+func EscapeLabelValue(value string) string {
+	quoted := strconv.Quote(value)
+	return quoted[1 : len(quoted)-1]
+}
+
+// GetHealthCheckApmTxRegex returns the regex of the health check transactions to exclude.
+// Health checks are excluded by default, unless `exclude_health_checks` is false or the SLO is already
+// limited to an exact `apm_tx`. `health_check_apm_tx_regex` overrides the built-in list.
+func GetHealthCheckApmTxRegex(options map[string]string) (string, error) {
+	excludeHealthChecks, err := GetBoolOption(options, "exclude_health_checks", true)
+	if err != nil {
+		return "", err
 	}
+	return FilterOptions{MetricProfile: options["metric_profile"], ApmTx: SplitOptionList(options["apm_tx"]),
+		ExcludeHealthChecks: excludeHealthChecks, HealthCheckApmTxRegex: options["health_check_apm_tx_regex"]}.
+		healthCheckApmTxRegex(), nil
+}
 
-	for _, option := range []string{"apm_tx_regex", "good_http_status_regex", "bad_http_status_regex"} {
-		value := options[option]
-		if value != "" {
-			_, err := regexp.Compile(value)
-			if err != nil {
-				errors += fmt.Sprintf("invalid regex '%v' for option '%s': %s.", value, option, err)
-			}
+func (o FilterOptions) healthCheckApmTxRegex() string {
+	if !o.ExcludeHealthChecks || len(o.ApmTx) > 0 {
+		return ""
+	}
+
+	if o.HealthCheckApmTxRegex != "" {
+		return o.HealthCheckApmTxRegex
+	}
+	return o.Profile().HealthCheckRegex
+}
+
+// GetSuccessMatchers returns the label matchers for successful requests, see FilterOptions.SuccessMatchers.
+func GetSuccessMatchers(options map[string]string, enforceSuccessFilter bool) ([]LabelMatcher, error) {
+	parsed, err := ParseFilterOptions(options)
+	if err != nil {
+		return nil, err
+	}
+	return parsed.SuccessMatchers(enforceSuccessFilter), nil
+}
+
+// GetStatusRegexes returns the good and bad status regexes, see FilterOptions.StatusRegexes.
+func GetStatusRegexes(options map[string]string, enforceSuccessFilter bool) (goodHTTPStatusRegex string,
+	badHTTPStatusRegex string) {
+	statusOptions := FilterOptions{
+		MetricProfile:       options["metric_profile"],
+		GoodHTTPStatusRegex: options["good_http_status_regex"],
+		BadHTTPStatusRegex:  options["bad_http_status_regex"],
+	}
+	if options["success_filter"] != "" {
+		statusOptions.SuccessFilter = []LabelMatcher{}
+	}
+	return statusOptions.StatusRegexes(enforceSuccessFilter)
+}
+
+// GetGoodStatusCodes returns the status values of the metric profile that are considered good,
+// e.g. the HTTP status codes between 100 and 599, evaluating the status regexes anchored like Prometheus does.
+// Status codes excluded by `exclude_from_total_http_status_regex` are never considered good.
+func GetGoodStatusCodes(options map[string]string, enforceSuccessFilter bool) ([]string, error) {
+	goodHTTPStatusRegex, badHTTPStatusRegex := GetStatusRegexes(options, enforceSuccessFilter)
+
+	var good, bad, excluded *regexp.Regexp
+	for _, status := range []struct {
+		regex  **regexp.Regexp
+		option string
+		value  string
+	}{
+		{&good, "good_http_status_regex", goodHTTPStatusRegex},
+		{&bad, "bad_http_status_regex", badHTTPStatusRegex},
+		{&excluded, "exclude_from_total_http_status_regex", options["exclude_from_total_http_status_regex"]},
+	} {
+		if status.value == "" {
+			continue
+		}
+		regex, err := regexp.Compile("^(?:" + status.value + ")$")
+		if err != nil {
+			return nil, &OptionError{Option: status.option, Value: status.value, Err: ErrInvalidRegex, Reason: err.Error()}
+		}
+		*status.regex = regex
+	}
+
+	var codes []string
+	profile := FilterOptions{MetricProfile: options["metric_profile"]}.Profile()
+	for _, status := range profile.StatusValues {
+		if (excluded != nil && excluded.MatchString(status)) || (good != nil && !good.MatchString(status)) ||
+			(bad != nil && bad.MatchString(status)) {
+			continue
+		}
+		codes = append(codes, status)
+	}
+	return codes, nil
+}
+
+// DescribeStatusCodes returns the sorted numeric status codes as a list of classes and ranges, e.g. `2xx, 404`,
+// other status values are listed as they are, e.g. `OK, NOT_FOUND`.
+func DescribeStatusCodes(values []string) string {
+	codes := make([]int, 0, len(values))
+	for _, value := range values {
+		code, err := strconv.Atoi(value)
+		if err != nil {
+			return strings.Join(values, ", ")
 		}
+		codes = append(codes, code)
 	}
 
-	if errors != "" {
-		return fmt.Errorf(errors)
+	var parts []string
+	for i := 0; i < len(codes); {
+		j := i
+		for j+1 < len(codes) && codes[j+1] == codes[j]+1 && codes[j+1]%100 != 0 {
+			j++
+		}
+		switch start, end := codes[i], codes[j]; {
+		case start%100 == 0 && end == start+99:
+			parts = append(parts, fmt.Sprintf("%dxx", start/100))
+		case start == end:
+			parts = append(parts, strconv.Itoa(start))
+		default:
+			parts = append(parts, fmt.Sprintf("%d-%d", start, end))
+		}
+		i = j + 1
 	}
-	return nil
+	if len(parts) == 0 {
+		return "none"
+	}
+	return strings.Join(parts, ", ")
 }
 
-func GetGeneralExpCommonFilter(options map[string]string) (string, error) {
-	servicename, _ := GetServiceName(options)
-	apmTx := options["apm_tx"]
-	apmTxRegex := options["apm_tx_regex"]
-	filter := options["filter"]
+// ValidateStatusCodes returns ValidationErrors when the status regexes consider no or all status codes good,
+// or when the good and bad status regexes shadow each other.
+func ValidateStatusCodes(options map[string]string, enforceSuccessFilter bool) error {
+	return validateStatusCodes(options, enforceSuccessFilter).ErrorOrNil()
+}
 
-	var buf bytes.Buffer
-	tplValues := map[string]string{
-		"servicename":  servicename,
-		"apm_tx":       apmTx,
-		"apm_tx_regex": apmTxRegex,
-		"filter":       PrepareFilter(filter),
+func validateStatusCodes(options map[string]string, enforceSuccessFilter bool) ValidationErrors {
+	goodHTTPStatusRegex, badHTTPStatusRegex := GetStatusRegexes(options, enforceSuccessFilter)
+	if goodHTTPStatusRegex == "" && badHTTPStatusRegex == "" {
+		return nil
 	}
 
-	err := generalFilterTpl.Execute(&buf, tplValues)
+	codes, err := GetGoodStatusCodes(options, enforceSuccessFilter)
 	if err != nil {
-		return "", fmt.Errorf("could not render query template: %w", err)
+		// invalid regexes are reported by the general validation
+		return nil
+	}
+
+	var validationErrors ValidationErrors
+	option, value := "good_http_status_regex", goodHTTPStatusRegex
+	if goodHTTPStatusRegex == "" {
+		option, value = "bad_http_status_regex", badHTTPStatusRegex
+	}
+
+	total, _ := GetGoodStatusCodes(map[string]string{
+		"metric_profile":                       options["metric_profile"],
+		"exclude_from_total_http_status_regex": options["exclude_from_total_http_status_regex"],
+	}, false)
+
+	statusDomain := FilterOptions{MetricProfile: options["metric_profile"]}.Profile().StatusDomain
+	switch {
+	case len(codes) == 0:
+		validationErrors.Add(option, value, ErrStatusCodeSet, "no "+statusDomain+" is considered good")
+	case len(codes) == len(total):
+		validationErrors.Add(option, value, ErrStatusCodeSet, "every "+statusDomain+" is considered good")
+	}
+
+	if goodHTTPStatusRegex != "" && badHTTPStatusRegex != "" && len(codes) > 0 {
+		onlyGood, _ := GetGoodStatusCodes(map[string]string{
+			"metric_profile":                       options["metric_profile"],
+			"good_http_status_regex":               goodHTTPStatusRegex,
+			"exclude_from_total_http_status_regex": options["exclude_from_total_http_status_regex"],
+		}, false)
+		if len(onlyGood) == len(codes) {
+			validationErrors.Add("bad_http_status_regex", badHTTPStatusRegex, ErrStatusCodeSet,
+				"is shadowed by good_http_status_regex, it excludes none of the status codes considered good")
+		}
+	}
+
+	return validationErrors
+}
+
+// LabelMatcher is a single PromQL label matcher, e.g. `APM_TRANSACTION=~"/product/.*"`.
+type LabelMatcher struct {
+	Name  string
+	Op    string
+	Value string
+}
+
+// String returns the canonical form of the label matcher.
+func (m LabelMatcher) String() string {
+	return m.Name + m.Op + `"` + EscapeLabelValue(m.Value) + `"`
+}
+
+// FormatMatchers returns the canonical, comma separated form of the label matchers.
+func FormatMatchers(matchers []LabelMatcher) string {
+	formatted := make([]string, 0, len(matchers))
+	for _, matcher := range matchers {
+		formatted = append(formatted, matcher.String())
+	}
+	return strings.Join(formatted, ", ")
+}
+
+var invertedOperators = map[string]string{"=": "!=", "!=": "=", "=~": "!~", "!~": "=~"}
+
+// InvertMatchers returns the label matchers with inverted operators,
+// so that requests matching any of the given matchers are excluded.
+func InvertMatchers(matchers []LabelMatcher) []LabelMatcher {
+	inverted := make([]LabelMatcher, 0, len(matchers))
+	for _, matcher := range matchers {
+		matcher.Op = invertedOperators[matcher.Op]
+		inverted = append(inverted, matcher)
+	}
+	return inverted
+}
+
+// ParseMatchers parses comma separated PromQL label matchers, optionally enclosed in braces,
+// e.g. `{CLIENT="TRIPADVISOR", REQUEST_SIZE_BUCKET=~"FIFTY|HUNDRED"}`.
+// Values can be quoted with double quotes, single quotes or backticks.
+func ParseMatchers(input string) ([]LabelMatcher, error) {
+	parser := matcherParser{input: input}
+	return parser.parse()
+}
+
+type matcherParser struct {
+	input string
+	pos   int
+}
+
+func (p *matcherParser) errorf(pos int, format string, args ...interface{}) error {
+	return fmt.Errorf("invalid filter at position %d: %s", pos+1, fmt.Sprintf(format, args...))
+}
+
+func (p *matcherParser) skipSpaces() {
+	for p.pos < len(p.input) && strings.ContainsRune(" \t\r\n", rune(p.input[p.pos])) {
+		p.pos++
 	}
+}
 
-	return buf.String(), nil
+// skipSeparators skips any whitespace and superfluous commas.
+func (p *matcherParser) skipSeparators() {
+	for p.skipSpaces(); p.pos < len(p.input) && p.input[p.pos] == ','; p.skipSpaces() {
+		p.pos++
+	}
 }
 
-// GetSuccessFilter returns a rendered template for successful requests.
-// when `enforceSuccessFilter` is true, a default `goodHTTPStatusRegex = "2.."` will be returned if nothing else is set.
-func GetSuccessFilter(options map[string]string, enforceSuccessFilter bool) (string, error) {
-	successFilter := options["success_filter"]
-	goodHTTPStatusRegex := options["good_http_status_regex"]
-	badHTTPStatusRegex := options["bad_http_status_regex"]
+func (p *matcherParser) parse() ([]LabelMatcher, error) {
+	var matchers []LabelMatcher
+
+	p.skipSeparators()
+	braced := p.pos < len(p.input) && p.input[p.pos] == '{'
+	if braced {
+		p.pos++
+	}
+
+	for {
+		p.skipSeparators()
+		if p.pos >= len(p.input) {
+			if braced {
+				return nil, p.errorf(p.pos, "missing closing '}'")
+			}
+			return matchers, nil
+		}
+
+		if p.input[p.pos] == '}' {
+			if !braced {
+				return nil, p.errorf(p.pos, "unexpected '}' without opening '{'")
+			}
+			p.pos++
+			p.skipSeparators()
+			if p.pos < len(p.input) {
+				return nil, p.errorf(p.pos, "unexpected '%c' after closing '}'", p.input[p.pos])
+			}
+			return matchers, nil
+		}
+
+		matcher, err := p.parseMatcher()
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, matcher)
 
-	if enforceSuccessFilter && (successFilter == "" && goodHTTPStatusRegex == "" && badHTTPStatusRegex == "") {
-		goodHTTPStatusRegex = "2.."
+		p.skipSpaces()
+		if p.pos < len(p.input) && p.input[p.pos] != ',' && p.input[p.pos] != '}' {
+			return nil, p.errorf(p.pos, "expected ',' or '}' after label matcher, got '%c'", p.input[p.pos])
+		}
+	}
+}
+
+func (p *matcherParser) parseMatcher() (LabelMatcher, error) {
+	name, err := p.parseLabelName()
+	if err != nil {
+		return LabelMatcher{}, err
 	}
 
-	var buf bytes.Buffer
-	tplValues := map[string]string{
-		"success_filter":         PrepareFilter(successFilter),
-		"good_http_status_regex": goodHTTPStatusRegex,
-		"bad_http_status_regex":  badHTTPStatusRegex,
+	p.skipSpaces()
+	op, err := p.parseOperator()
+	if err != nil {
+		return LabelMatcher{}, err
 	}
 
-	err := successFilterTpl.Execute(&buf, tplValues)
+	p.skipSpaces()
+	valuePos := p.pos
+	value, err := p.parseString()
 	if err != nil {
-		return "", fmt.Errorf("could not render query template: %w", err)
+		return LabelMatcher{}, err
+	}
+
+	if op == "=~" || op == "!~" {
+		if _, err := regexp.Compile(value); err != nil {
+			return LabelMatcher{}, p.errorf(valuePos, "invalid regex for label '%s': %s", name, err)
+		}
+	}
+
+	return LabelMatcher{Name: name, Op: op, Value: value}, nil
+}
+
+func (p *matcherParser) parseLabelName() (string, error) {
+	start := p.pos
+	for p.pos < len(p.input) && isLabelNameChar(p.input[p.pos], p.pos == start) {
+		p.pos++
+	}
+	if p.pos == start {
+		return "", p.errorf(start, "expected label name, got '%c'", p.input[start])
+	}
+	return p.input[start:p.pos], nil
+}
+
+// isLabelName returns whether the name is a valid Prometheus label name.
+func isLabelName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		if !isLabelNameChar(name[i], i == 0) {
+			return false
+		}
+	}
+	return true
+}
+
+func isLabelNameChar(c byte, first bool) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (!first && c >= '0' && c <= '9')
+}
+
+func (p *matcherParser) parseOperator() (string, error) {
+	for _, op := range []string{"=~", "!~", "!=", "="} {
+		if strings.HasPrefix(p.input[p.pos:], op) {
+			p.pos += len(op)
+			return op, nil
+		}
+	}
+	if p.pos >= len(p.input) {
+		return "", p.errorf(p.pos, "expected label matching operator, got end of filter")
+	}
+	return "", p.errorf(p.pos, "expected label matching operator ('=', '!=', '=~' or '!~'), got '%c'", p.input[p.pos])
+}
+
+func (p *matcherParser) parseString() (string, error) {
+	start := p.pos
+	if p.pos >= len(p.input) {
+		return "", p.errorf(start, "expected quoted label value, got end of filter")
+	}
+
+	quote := p.input[p.pos]
+	if quote != '"' && quote != '\'' && quote != '`' {
+		return "", p.errorf(start, "expected quoted label value, got '%c'", quote)
+	}
+
+	for p.pos++; p.pos < len(p.input); p.pos++ {
+		switch p.input[p.pos] {
+		case '\\':
+			if quote != '`' {
+				p.pos++
+			}
+		case quote:
+			p.pos++
+			value, err := unquoteLabelValue(p.input[start:p.pos])
+			if err != nil {
+				return "", p.errorf(start, "invalid quoted label value %s", p.input[start:p.pos])
+			}
+			return value, nil
+		}
+	}
+	return "", p.errorf(start, "unterminated quoted label value")
+}
+
+// unquoteLabelValue unquotes a PromQL string, single quoted strings are converted to double quoted strings first.
+func unquoteLabelValue(quoted string) (string, error) {
+	if quoted[0] != '\'' {
+		return strconv.Unquote(quoted)
 	}
 
-	return buf.String(), nil
+	var body strings.Builder
+	inner := quoted[1 : len(quoted)-1]
+	for i := 0; i < len(inner); i++ {
+		switch {
+		case inner[i] == '\\' && i+1 < len(inner):
+			if inner[i+1] == '\'' {
+				body.WriteByte('\'')
+			} else {
+				body.WriteString(inner[i : i+2])
+			}
+			i++
+		case inner[i] == '"':
+			body.WriteString(`\"`)
+		default:
+			body.WriteByte(inner[i])
+		}
+	}
+	return strconv.Unquote(`"` + body.String() + `"`)
+}
+
+// SlothWindow is the placeholder sloth replaces with the SLO window.
+const SlothWindow = "{{.window}}"
+
+// MetricProfile describes a request metric the plugins can be used with, selected with `metric_profile`.
+// Metric is the base name of the histogram with `_count` and `_bucket` series in ms,
+// JobSuffix is appended to `servicename` for the job, unless `job_suffix` is set.
+// StatusValues are all values of the status label, StatusDomain describes them in validation messages,
+// GoodStatusRegex is the default of `good_http_status_regex` and HealthCheckRegex of `health_check_apm_tx_regex`.
+type MetricProfile struct {
+	Name             string
+	Metric           string
+	TransactionLabel string
+	StatusLabel      string
+	JobSuffix        string
+	GoodStatusRegex  string
+	StatusValues     []string
+	StatusDomain     string
+	HealthCheckRegex string
+}
+
+// DefaultMetricProfile is the profile of the ELAPSED_TIME_MS metrics of the Viator services.
+const DefaultMetricProfile = "request_elapsed_time_ms"
+
+// Metric profiles of the outbound client and gRPC ELAPSED_TIME_MS metrics, using the same buckets.
+const (
+	ClientMetricProfile = "client_request_elapsed_time_ms"
+	GRPCMetricProfile   = "grpc_request_elapsed_time_ms"
+)
+
+// DefaultGRPCHealthCheckRegex matches the methods of the standard gRPC health service.
+const DefaultGRPCHealthCheckRegex = "grpc.health.v1.Health/.*"
+
+// MetricProfiles are the known metric profiles, new request metrics are supported by adding a profile.
+var MetricProfiles = []MetricProfile{
+	{Name: DefaultMetricProfile, Metric: "request:ELAPSED_TIME_MS", TransactionLabel: "APM_TRANSACTION",
+		StatusLabel: "RESPONSE_STATUS", JobSuffix: DefaultJobSuffix, GoodStatusRegex: "2..",
+		StatusValues: httpStatusCodes(), StatusDomain: "status code between 100 and 599",
+		HealthCheckRegex: DefaultHealthCheckApmTxRegex},
+	{Name: ClientMetricProfile, Metric: "client_request:ELAPSED_TIME_MS", TransactionLabel: "APM_TRANSACTION",
+		StatusLabel: "RESPONSE_STATUS", JobSuffix: DefaultJobSuffix, GoodStatusRegex: "2..",
+		StatusValues: httpStatusCodes(), StatusDomain: "status code between 100 and 599",
+		HealthCheckRegex: DefaultHealthCheckApmTxRegex},
+	{Name: GRPCMetricProfile, Metric: "grpc_request:ELAPSED_TIME_MS", TransactionLabel: "GRPC_METHOD",
+		StatusLabel: "GRPC_STATUS", JobSuffix: DefaultJobSuffix, GoodStatusRegex: "OK",
+		StatusValues: grpcStatusCodes, StatusDomain: "gRPC status code", HealthCheckRegex: DefaultGRPCHealthCheckRegex},
+}
+
+// httpStatusCodes returns the HTTP status codes between 100 and 599.
+func httpStatusCodes() []string {
+	codes := make([]string, 0, 500)
+	for code := 100; code <= 599; code++ {
+		codes = append(codes, strconv.Itoa(code))
+	}
+	return codes
+}
+
+// grpcStatusCodes are the names of the gRPC status codes.
+var grpcStatusCodes = []string{"OK", "CANCELLED", "UNKNOWN", "INVALID_ARGUMENT", "DEADLINE_EXCEEDED", "NOT_FOUND",
+	"ALREADY_EXISTS", "PERMISSION_DENIED", "RESOURCE_EXHAUSTED", "FAILED_PRECONDITION", "ABORTED", "OUT_OF_RANGE",
+	"UNIMPLEMENTED", "INTERNAL", "UNAVAILABLE", "DATA_LOSS", "UNAUTHENTICATED"}
+
+// GetMetricProfile returns the metric profile with the name, the default profile for an empty name.
+func GetMetricProfile(name string) (MetricProfile, bool) {
+	if name = strings.TrimSpace(name); name == "" {
+		name = DefaultMetricProfile
+	}
+	for _, profile := range MetricProfiles {
+		if profile.Name == name {
+			return profile, true
+		}
+	}
+	return MetricProfile{}, false
+}
+
+// MetricProfileNames returns the names of the known metric profiles.
+func MetricProfileNames() []string {
+	names := make([]string, 0, len(MetricProfiles))
+	for _, profile := range MetricProfiles {
+		names = append(names, profile.Name)
+	}
+	return names
+}
+
+// firstClassOptions returns the options to use instead of filter matchers on the labels of the profile.
+func (p MetricProfile) firstClassOptions() map[string]string {
+	return map[string]string{
+		"job":              "servicename, job, job_regex or job_suffix",
+		p.TransactionLabel: "apm_tx, apm_tx_glob, apm_tx_regex, apm_tx_exclude or apm_tx_exclude_regex",
+		p.StatusLabel:      "good_http_status_regex, bad_http_status_regex or exclude_from_total_http_status_regex",
+	}
+}
+
+// OptionsHash returns a short hash of the options, independent of their order.
+func OptionsHash(options map[string]string) string {
+	keys := make([]string, 0, len(options))
+	for key := range options {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	hash := sha256.New()
+	for _, key := range keys {
+		fmt.Fprintf(hash, "%s=%s\n", key, options[key])
+	}
+	return "sha256:" + hex.EncodeToString(hash.Sum(nil))[:12]
+}
+
+// TraceHeader returns the PromQL comment lines tracing a query back to the plugin and the options that produced it.
+// The options should be the effective options, leaving out defaults, so equivalent options share their hash.
+func TraceHeader(pluginID string, options map[string]string, notes ...string) string {
+	var header strings.Builder
+	fmt.Fprintf(&header, "# plugin: %s\n", pluginID)
+	fmt.Fprintf(&header, "# options: %s\n", OptionsHash(options))
+	for _, note := range notes {
+		fmt.Fprintf(&header, "# note: %s\n", note)
+	}
+	return header.String()
+}
+
+// Expr is a PromQL expression node writing itself at the indentation of its line.
+// It is a function rather than an interface, as the Yaegi interpreter of sloth
+// can not hold values of interpreted types in interfaces.
+type Expr func(b *strings.Builder, indent int)
+
+// Selector returns a vector selector, e.g. `metric{job="x"}`, which is a range vector selector when rangeWindow is set.
+func Selector(metric string, matchers []LabelMatcher, rangeWindow string) Expr {
+	return func(b *strings.Builder, _ int) {
+		b.WriteString(metric)
+		b.WriteString("{")
+		b.WriteString(FormatMatchers(matchers))
+		b.WriteString("}")
+		if rangeWindow != "" {
+			b.WriteString("[" + rangeWindow + "]")
+		}
+	}
+}
+
+// Call returns a function or aggregation call, e.g. `sum(x)`.
+func Call(function string, args ...Expr) Expr {
+	return func(b *strings.Builder, indent int) {
+		b.WriteString(function + "(")
+		for i, arg := range args {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			arg(b, indent)
+		}
+		b.WriteString(")")
+	}
+}
+
+// Rate returns `rate(expr)`.
+func Rate(expr Expr) Expr {
+	return Call("rate", expr)
 }
 
-var regxCommaFormat = regexp.MustCompile(", *")
-var regxEquals = regexp.MustCompile(`\s*=\s*`)
+// Sum returns `sum(expr)`.
+func Sum(expr Expr) Expr {
+	return Call("sum", expr)
+}
+
+// Vector returns `vector(value)`.
+func Vector(value string) Expr {
+	return Call("vector", Number(value))
+}
+
+// Number returns a number literal, kept as formatted by the caller.
+func Number(value string) Expr {
+	return func(b *strings.Builder, _ int) {
+		b.WriteString(value)
+	}
+}
+
+// Paren returns `(expr)`.
+func Paren(expr Expr) Expr {
+	return func(b *strings.Builder, indent int) {
+		b.WriteString("(")
+		expr(b, indent)
+		b.WriteString(")")
+	}
+}
+
+// MultilineParen returns `(expr)` with the expression on its own indented line.
+func MultilineParen(expr Expr) Expr {
+	return func(b *strings.Builder, indent int) {
+		b.WriteString("(\n" + strings.Repeat("\t", indent+1))
+		expr(b, indent+1)
+		b.WriteString("\n" + strings.Repeat("\t", indent) + ")")
+	}
+}
 
-func PrepareFilter(filter string) string {
-	filter = strings.Trim(filter, "}{, ")
-	if filter != "" {
-		// make it prettier
-		filter = ", " + filter
-		filter = strings.Join(strings.Fields(filter), " ")
-		filter = regxCommaFormat.ReplaceAllString(filter, ", ")
-		filter = regxEquals.ReplaceAllString(filter, "=")
+// Binary returns `lhs op rhs`, the operator may include a vector matching, e.g. `OR on()`.
+func Binary(op string, lhs Expr, rhs Expr) Expr {
+	return func(b *strings.Builder, indent int) {
+		lhs(b, indent)
+		b.WriteString(" " + op + " ")
+		rhs(b, indent)
 	}
-	return filter
+}
+
+// MultilineBinary returns `lhs op rhs` with the operator on its own line.
+func MultilineBinary(op string, lhs Expr, rhs Expr) Expr {
+	return func(b *strings.Builder, indent int) {
+		lhs(b, indent)
+		b.WriteString("\n" + strings.Repeat("\t", indent) + op + "\n" + strings.Repeat("\t", indent))
+		rhs(b, indent)
+	}
+}
+
+// OrOn returns `lhs OR on() rhs`.
+func OrOn(lhs Expr, rhs Expr) Expr {
+	return Binary("OR on()", lhs, rhs)
+}
+
+// ErrorRatio returns the error ratio `1 - good / total`.
+// Without any requests the ratio falls back to `vector(1)`, so the error ratio is 0.
+func ErrorRatio(good Expr, total Expr) Expr {
+	ratio := MultilineBinary("/", good, Paren(Binary(">", total, Number("0"))))
+	return Binary("-", Number("1"), Paren(OrOn(MultilineParen(ratio), Vector("1"))))
+}
+
+// FormatExpr returns the PromQL of the expression.
+func FormatExpr(expr Expr) string {
+	var b strings.Builder
+	expr(&b, 0)
+	return b.String()
 }
 
 const (
@@ -136,26 +1992,23 @@ const (
 	SLIPluginID = "viator-sloth-plugins/request_elapsed_time_ms/latency"
 )
 
-var queryForExactBucketsTpl = template.Must(template.New("").Parse(`
-1 - ((
-	sum(rate(request:ELAPSED_TIME_MS_bucket{ {{- .general_exp_common_filter -}} {{- .success_exp_common_filter -}}, le="{{ .le }}.0"}[{{"{{.window}}"}}]))
-	/
-	(sum(rate(request:ELAPSED_TIME_MS_count{ {{- .general_exp_common_filter -}} }[{{"{{.window}}"}}])) > 0)
-) OR on() vector(1))
-`))
-
-// When the latency is between two buckets, the good values are
-// good = (lowerBucketValue + (highBucketValue-lowBucketValue) * ratio .
-var queryForRatiosTpl = template.Must(template.New("").Parse(`
-1 - ((
-	(
-	(1-{{.ratio}}) * sum(rate(request:ELAPSED_TIME_MS_bucket{ {{- .general_exp_common_filter -}}, le="{{ .lowerBucket }}.0" {{- .success_exp_common_filter -}}}[{{"{{.window}}"}}]))
-	+ {{.ratio}} * sum(rate(request:ELAPSED_TIME_MS_bucket{ {{- .general_exp_common_filter -}}, le="{{ .upperBucket }}.0" {{- .success_exp_common_filter -}}}[{{"{{.window}}"}}]))
-	)
-	/
-	(sum(rate(request:ELAPSED_TIME_MS_count{ {{- .general_exp_common_filter -}} }[{{"{{.window}}"}}])) > 0)
-) OR on() vector(1))
-`))
+// OptionSchema describes every option of the plugin.
+var OptionSchema = append([]OptionSpec{
+	{Name: "latency", Type: IntOption, Mandatory: true, Minimum: LowestBucket, Maximum: TopBucket,
+		Description: "the latency in ms that is considered a successful/good response, anything above is considered bad",
+		Example:     "250"},
+}, GeneralOptionSpecs...)
+
+// UsageExamples are the documented examples of the plugin options.
+var UsageExamples = []UsageExample{
+	{Title: "Without filter (minimum)",
+		Description: "requests of up to 250ms are considered good, the latency matches a histogram bucket",
+		Options:     map[string]string{"servicename": "demandproduct", "apm_tx": "/product/filter", "latency": "250"}},
+	{Title: "With filters",
+		Description: "requests of up to 300ms are considered good, interpolated linearly between the 250ms and 500ms buckets",
+		Options: map[string]string{"servicename": "demandproduct", "apm_tx": "/product/filter", "latency": "300",
+			"filter": `REQUEST_SIZE_BUCKET="FIFTY", CLIENT="TRIPADVISOR"`}},
+}
 
 // as defined here (internal):
 // experiences-common/-/blob/develop/experiences-common-shared/src/main/java/com/tripadvisor/experiences/common/shared/performance/ResponseTimeBucket.java.
@@ -198,76 +2051,214 @@ func GetBucketRatio(latency int) float32 {
 	return float32(math.Round(float64(ratio*1e13))) / 1e13
 }
 
-// SLIPlugin will return a query that will return the availability error based on ELAPSED_TIME_MS_count service metrics.
-func SLIPlugin(_ context.Context, _, _, options map[string]string) (string, error) {
+// ValidateOptions returns warnings and ValidationErrors listing every problem of the options.
+func ValidateOptions(options map[string]string) ([]OptionWarning, error) {
+	var validationErrors ValidationErrors
+	validationErrors.Append(validateKnownOptions(options, OptionNames(OptionSchema))...)
+	validationErrors.Append(validateGeneralExpCommonFilterOptions(options)...)
+	_, latencyErr := validateLatencyOption(options)
+	validationErrors.Append(latencyErr)
+	validationErrors.Append(validateStatusCodes(options, false)...)
+	warnings, ruleErrors := evaluateOptionRules(options, GeneralOptionRules)
+	validationErrors.Append(ruleErrors...)
+	lintWarnings, lintErrors := lintRegexes(options)
+	validationErrors.Append(lintErrors...)
+	warnings = append(warnings, lintWarnings...)
+	return warnings, validationErrors.ErrorOrNil()
+}
+
+// AdvisoryLatencyFarFromBucket is the advisory code of latencies far from any histogram bucket.
+const AdvisoryLatencyFarFromBucket = "latency-far-from-bucket"
+
+// MaxBucketDistance is the relative distance of the latency to the closest bucket above which it is advised against.
+const MaxBucketDistance = 0.2
+
+// Advise returns best-practice advisories of the options that do not block rendering the query.
+func Advise(options map[string]string) []Advisory {
+	warnings, _ := ValidateOptions(options)
+	advisories := GetGeneralAdvisories(options, warnings)
 
-	err := ValidateGeneralExpCommonFilterOptions(options)
-	if err != nil {
-		return "", err
-	}
-	serviceName, _ := GetServiceName(options)
 	latency, err := validateLatencyOption(options)
 	if err != nil {
-		return "", err
+		return advisories
 	}
+	lowerBucketValue, upperBucketValue, _ := GetBucketValues(latency)
+	closestBucket := lowerBucketValue
+	if upperBucketValue-latency < latency-lowerBucketValue {
+		closestBucket = upperBucketValue
+	}
+	if distance := math.Abs(float64(latency-closestBucket)) / float64(latency); distance > MaxBucketDistance {
+		advisories = append(advisories, Advisory{Code: AdvisoryLatencyFarFromBucket, Option: "latency",
+			Message: fmt.Sprintf("is %.0f%% away from the closest bucket %d, the good requests are interpolated "+
+				"linearly between the buckets %d and %d, prefer a latency close to a bucket",
+				distance*100, closestBucket, lowerBucketValue, upperBucketValue)})
+	}
+	return advisories
+}
 
-	generalFilter, err := GetGeneralExpCommonFilter(options)
-	if err != nil {
-		return "", fmt.Errorf("could not generate general filter for '%s': %w", serviceName, err)
+// Options are the typed options of the plugin.
+type Options struct {
+	FilterOptions
+	// Latency is the target latency in ms.
+	Latency int
+}
+
+// ParseOptions validates the options and returns them typed.
+func ParseOptions(options map[string]string) (Options, error) {
+	if _, err := ValidateOptions(options); err != nil {
+		return Options{}, err
 	}
 
-	generalSuccessFilter, err := GetSuccessFilter(options, false)
+	filterOptions, err := ParseFilterOptions(options)
 	if err != nil {
-		return "", fmt.Errorf("could not generate general success filter for '%s': %w", serviceName, err)
+		return Options{}, err
 	}
+	latency, _ := validateLatencyOption(options)
+	return Options{FilterOptions: filterOptions, Latency: latency}, nil
+}
 
-	lowerBucketValue, upperBucketValue, err := GetBucketValues(latency)
-	if err != nil {
-		// validation should prevent that
+// Map returns the options as sloth plugin options, leaving out empty and default values.
+func (o Options) Map() map[string]string {
+	options := o.FilterOptions.Map()
+	if o.Latency != 0 {
+		options["latency"] = strconv.Itoa(o.Latency)
+	}
+	return options
+}
+
+// Latency sets the target latency in ms.
+func (b *QueryBuilder) Latency(latency int) *QueryBuilder {
+	b.options.Latency = latency
+	return b
+}
+
+// Query validates the options and returns the query.
+func (o Options) Query() (string, error) {
+	if _, err := ValidateOptions(o.Map()); err != nil {
 		return "", err
 	}
+	return o.query(), nil
+}
+
+func (o Options) query() string {
+	generalMatchers := o.GeneralMatchers()
+	successMatchers := o.SuccessMatchers(false)
+
+	metric := o.Profile().Metric
+	lowerBucketValue, upperBucketValue, _ := GetBucketValues(o.Latency)
 
-	var b bytes.Buffer
-	var data map[string]string
-	var query *template.Template
+	var good Expr
 	if lowerBucketValue == upperBucketValue {
-		data = map[string]string{
-			"general_exp_common_filter": generalFilter,
-			"success_exp_common_filter": generalSuccessFilter,
-			"le":                        strconv.Itoa(latency),
-		}
-		query = queryForExactBucketsTpl
+		goodMatchers := append(append([]LabelMatcher{}, generalMatchers...), successMatchers...)
+		good = Sum(Rate(bucketSelector(metric, goodMatchers, nil, o.Latency)))
 	} else {
-		latencyRatio := GetBucketRatio(latency)
-		data = map[string]string{
-			"general_exp_common_filter": generalFilter,
-			"success_exp_common_filter": generalSuccessFilter,
-			"lowerBucket":               strconv.Itoa(lowerBucketValue),
-			"upperBucket":               strconv.Itoa(upperBucketValue),
-			"ratio":                     strconv.FormatFloat(float64(latencyRatio), 'f', 6, 32),
+		// When the latency is between two buckets, the good values are
+		// good = (lowerBucketValue + (highBucketValue-lowBucketValue) * ratio .
+		good = bucketInterpolation(strconv.FormatFloat(float64(GetBucketRatio(o.Latency)), 'f', 6, 32),
+			Sum(Rate(bucketSelector(metric, generalMatchers, successMatchers, lowerBucketValue))),
+			Sum(Rate(bucketSelector(metric, generalMatchers, successMatchers, upperBucketValue))))
+	}
+
+	query := ErrorRatio(good, Sum(Rate(Selector(metric+"_count", generalMatchers, SlothWindow))))
+
+	header := ""
+	if o.TraceHeader {
+		var notes []string
+		if estimate := o.bucketEstimate(); estimate != "" {
+			notes = append(notes, fmt.Sprintf("latency %dms is %s", o.Latency, estimate))
 		}
-		query = queryForRatiosTpl
+		header = TraceHeader(SLIPluginID, o.Map(), notes...)
+	}
+	return "\n" + header + FormatExpr(query) + "\n"
+}
+
+// Explain validates the options and returns a human-readable explanation of the SLI, e.g. for SLO docs and reviews.
+func Explain(options map[string]string) (string, error) {
+	parsed, err := ParseOptions(options)
+	if err != nil {
+		return "", err
+	}
+	return parsed.Explain(), nil
+}
+
+// Explain returns a human-readable explanation of the SLI, including how the latency maps to the histogram buckets.
+func (o Options) Explain() string {
+	good := o.ExplainRequests()
+	if estimate := o.bucketEstimate(); estimate != "" {
+		good += fmt.Sprintf(", with latency ≤%dms (%s)", o.Latency, estimate)
+	} else {
+		good += fmt.Sprintf(", with latency ≤%dms (the %dms bucket)", o.Latency, o.Latency)
+	}
+
+	total := "the same requests with any latency"
+	if success := o.ExplainSuccess(false); success != "" {
+		good += "; " + success
+		total = "the same requests with any status and latency"
+	}
+	return fmt.Sprintf("Good = %s; Total = %s; %s.", good, total, explainNoRequests)
+}
+
+// bucketEstimate describes how the good requests are estimated from the histogram buckets,
+// empty when the latency is on a bucket.
+func (o Options) bucketEstimate() string {
+	lowerBucketValue, upperBucketValue, _ := GetBucketValues(o.Latency)
+	if lowerBucketValue == upperBucketValue {
+		return ""
+	}
+	return fmt.Sprintf("estimated between the %dms and %dms buckets at ratio %.2f",
+		lowerBucketValue, upperBucketValue, GetBucketRatio(o.Latency))
+}
+
+// SLIPlugin will return a query that will return the availability error based on ELAPSED_TIME_MS_count service metrics.
+func SLIPlugin(_ context.Context, meta, labels, options map[string]string) (string, error) {
+	options, err := ApplySLODefaults(meta, labels, options)
+	if err != nil {
+		return "", err
 	}
-	err = query.Execute(&b, data)
+	parsed, err := ParseOptions(options)
 	if err != nil {
-		return "", fmt.Errorf("could not render query template for '%s': %w", serviceName, err)
+		return "", err
 	}
+	return parsed.query(), nil
+}
+
+// bucketSelector returns the selector of the histogram bucket of the metric counting requests up to the bucket value,
+// with the `le` matcher between the leading and trailing matchers. The query on a bucket puts `le` last,
+// the query between two buckets before the success matchers.
+func bucketSelector(metric string, leading, trailing []LabelMatcher, bucket int) Expr {
+	matchers := append([]LabelMatcher{}, leading...)
+	matchers = append(matchers, LabelMatcher{Name: "le", Op: "=", Value: strconv.Itoa(bucket) + ".0"})
+	return Selector(metric+"_bucket", append(matchers, trailing...), SlothWindow)
+}
 
-	return b.String(), nil
+// bucketInterpolation returns the good requests estimated linearly between the lower and upper bucket,
+// laid out as `(1-ratio) * lower + ratio * upper` with each term on its own line.
+func bucketInterpolation(ratio string, lower Expr, upper Expr) Expr {
+	return func(b *strings.Builder, indent int) {
+		tabs := strings.Repeat("\t", indent)
+		b.WriteString("(\n" + tabs + "(1-" + ratio + ") * ")
+		lower(b, indent)
+		b.WriteString("\n" + tabs + "+ " + ratio + " * ")
+		upper(b, indent)
+		b.WriteString("\n" + tabs + ")")
+	}
 }
 
-func validateLatencyOption(options map[string]string) (int, error) {
+func validateLatencyOption(options map[string]string) (int, *OptionError) {
 	latencyString := strings.TrimSpace(options["latency"])
+	reason := fmt.Sprintf("needs to be a number between the lowest bucket %v and the highest bucket %v",
+		LowestBucket, TopBucket)
 
 	if latencyString == "" {
-		return 0, fmt.Errorf("latency is mandatory and needs to be a number less than %v", TopBucket)
+		return 0, &OptionError{Option: "latency", Err: ErrMissingMandatory, Reason: reason}
 	}
 
 	latency, err := strconv.ParseInt(latencyString, 10, 32)
-	if err != nil || int(latency) <= 0 || int(latency) > TopBucket {
-		return 0, fmt.Errorf(
-			"latency is mandatory and needs to be a number greater than 0 less than %v, but was '%v', %w",
-			TopBucket, latencyString, err)
+	if err != nil {
+		return 0, &OptionError{Option: "latency", Value: latencyString, Err: ErrInvalidValue, Reason: reason}
+	}
+	if int(latency) < LowestBucket || int(latency) > TopBucket {
+		return 0, &OptionError{Option: "latency", Value: latencyString, Err: ErrOutOfRange, Reason: reason}
 	}
 	return int(latency), nil
 }
@@ -2,10 +2,16 @@ package latency_test
 
 import (
 	"context"
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"regexp"
 	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/viatorinc/sloth-common-metric-plugins/internal/promqltest"
 	"github.com/viatorinc/sloth-common-metric-plugins/plugins/request_elapsed_time_ms/latency"
 )
 
@@ -161,7 +167,7 @@ func TestSLIPlugin(t *testing.T) {
 		},
 
 		"A servicename and latency without filters should return a valid query.": {
-			options: map[string]string{"servicename": "demandproduct", "latency": "100"},
+			options: map[string]string{"servicename": "demandproduct", "latency": "100", "exclude_health_checks": "false"},
 			expQuery: `
 1 - ((
 	sum(rate(request:ELAPSED_TIME_MS_bucket{job="demandproduct-metrics", le="100.0"}[{{.window}}]))
@@ -198,27 +204,27 @@ func TestSLIPlugin(t *testing.T) {
 				"servicename":            "demandproduct",
 				"latency":                "175",
 				"apm_tx":                 "someapm",
-				"apm_tx_regex":           ".*apm.*",
 				"filter":                 `r="v",s="w"`,
 				"success_filter":         `o="g",p="h"`,
 				"good_http_status_regex": `[2-4]..`,
-				"bad_http_status_regex":  `[404|302]`,
+				"bad_http_status_regex":  `(404|302)`,
 			},
 			expQuery: `
 1 - ((
 	(
-	(1-0.500000) * sum(rate(request:ELAPSED_TIME_MS_bucket{job="demandproduct-metrics", APM_TRANSACTION="someapm", APM_TRANSACTION=~".*apm.*", r="v", s="w", le="100.0", RESPONSE_STATUS=~"[2-4]..", RESPONSE_STATUS!~"[404|302]", o="g", p="h"}[{{.window}}]))
-	+ 0.500000 * sum(rate(request:ELAPSED_TIME_MS_bucket{job="demandproduct-metrics", APM_TRANSACTION="someapm", APM_TRANSACTION=~".*apm.*", r="v", s="w", le="250.0", RESPONSE_STATUS=~"[2-4]..", RESPONSE_STATUS!~"[404|302]", o="g", p="h"}[{{.window}}]))
+	(1-0.500000) * sum(rate(request:ELAPSED_TIME_MS_bucket{job="demandproduct-metrics", APM_TRANSACTION="someapm", r="v", s="w", le="100.0", RESPONSE_STATUS=~"[2-4]..", RESPONSE_STATUS!~"(404|302)", o="g", p="h"}[{{.window}}]))
+	+ 0.500000 * sum(rate(request:ELAPSED_TIME_MS_bucket{job="demandproduct-metrics", APM_TRANSACTION="someapm", r="v", s="w", le="250.0", RESPONSE_STATUS=~"[2-4]..", RESPONSE_STATUS!~"(404|302)", o="g", p="h"}[{{.window}}]))
 	)
 	/
-	(sum(rate(request:ELAPSED_TIME_MS_count{job="demandproduct-metrics", APM_TRANSACTION="someapm", APM_TRANSACTION=~".*apm.*", r="v", s="w"}[{{.window}}])) > 0)
+	(sum(rate(request:ELAPSED_TIME_MS_count{job="demandproduct-metrics", APM_TRANSACTION="someapm", r="v", s="w"}[{{.window}}])) > 0)
 ) OR on() vector(1))`,
 		},
 		"Filters should be sanitized with ','.": {
 			options: map[string]string{
-				"servicename":    "test",
-				"latency":        "100",
-				"success_filter": `,k1="v2",k2="v2",`,
+				"servicename":           "test",
+				"latency":               "100",
+				"success_filter":        `,k1="v2",k2="v2",`,
+				"exclude_health_checks": "false",
 			},
 			expQuery: `
 1 - ((
@@ -229,18 +235,282 @@ func TestSLIPlugin(t *testing.T) {
 		},
 
 		"Filter should be sanitized with '{'.": {
+			options: map[string]string{
+				"servicename":           "test",
+				"latency":               "100",
+				"success_filter":        `{k1 = "v2", k2 = "v2"}, `,
+				"exclude_health_checks": "false",
+			},
+			expQuery: `
+1 - ((
+	sum(rate(request:ELAPSED_TIME_MS_bucket{job="test-metrics", k1="v2", k2="v2", le="100.0"}[{{.window}}]))
+	/
+	(sum(rate(request:ELAPSED_TIME_MS_count{job="test-metrics"}[{{.window}}])) > 0)
+) OR on() vector(1))`,
+		},
+
+		"Excluded requests should be removed from total and success queries.": {
+			options: map[string]string{
+				"servicename":                          "test",
+				"latency":                              "100",
+				"exclude_from_total_http_status_regex": "4..",
+				"exclude_filter":                       `CLIENT = "bot"`,
+				"exclude_health_checks":                "false",
+			},
+			expQuery: `
+1 - ((
+	sum(rate(request:ELAPSED_TIME_MS_bucket{job="test-metrics", RESPONSE_STATUS!~"4..", CLIENT!="bot", le="100.0"}[{{.window}}]))
+	/
+	(sum(rate(request:ELAPSED_TIME_MS_count{job="test-metrics", RESPONSE_STATUS!~"4..", CLIENT!="bot"}[{{.window}}])) > 0)
+) OR on() vector(1))`,
+		},
+
+		"A misspelled latency option should fail.": {
+			options: map[string]string{"servicename": "test", "latency": "100", "latancy": "150"},
+			expErr:  true,
+		},
+		"The service and labels of the SLO should be used as option defaults.": {
+			meta:    map[string]string{"service": "demandproduct"},
+			labels:  map[string]string{"env": "prod"},
+			options: map[string]string{"latency": "250", "apm_tx": "/product/full", "filter_from_labels": "env"},
+			expQuery: `
+1 - ((
+	sum(rate(request:ELAPSED_TIME_MS_bucket{job="demandproduct-metrics", APM_TRANSACTION="/product/full", env="prod", le="250.0"}[{{.window}}]))
+	/
+	(sum(rate(request:ELAPSED_TIME_MS_count{job="demandproduct-metrics", APM_TRANSACTION="/product/full", env="prod"}[{{.window}}])) > 0)
+) OR on() vector(1))`,
+		},
+
+		"Every exclude filter matcher should exclude the requests it matches on its own.": {
 			options: map[string]string{
 				"servicename":    "test",
 				"latency":        "100",
-				"success_filter": `{k1 = "v2", k2 = "v2"}, `,
+				"apm_tx":         "/product/full",
+				"exclude_filter": `CLIENT="bot", REGION="eu"`,
 			},
 			expQuery: `
 1 - ((
-	sum(rate(request:ELAPSED_TIME_MS_bucket{job="test-metrics", k1="v2", k2="v2", le="100.0"}[{{.window}}]))
+	sum(rate(request:ELAPSED_TIME_MS_bucket{job="test-metrics", APM_TRANSACTION="/product/full", CLIENT!="bot", REGION!="eu", le="100.0"}[{{.window}}]))
+	/
+	(sum(rate(request:ELAPSED_TIME_MS_count{job="test-metrics", APM_TRANSACTION="/product/full", CLIENT!="bot", REGION!="eu"}[{{.window}}])) > 0)
+) OR on() vector(1))`,
+		},
+
+		"Health checks should be excluded by default.": {
+			options: map[string]string{"servicename": "test", "latency": "100"},
+			expQuery: `
+1 - ((
+	sum(rate(request:ELAPSED_TIME_MS_bucket{job="test-metrics", APM_TRANSACTION!~"/ping|/health|/healthcheck|/healthz|/ready|/readiness|/readyz|/live|/liveness|/livez|/actuator/health(/.*)?", le="100.0"}[{{.window}}]))
+	/
+	(sum(rate(request:ELAPSED_TIME_MS_count{job="test-metrics", APM_TRANSACTION!~"/ping|/health|/healthcheck|/healthz|/ready|/readiness|/readyz|/live|/liveness|/livez|/actuator/health(/.*)?"}[{{.window}}])) > 0)
+) OR on() vector(1))`,
+		},
+
+		"Health checks should not be excluded when disabled.": {
+			options: map[string]string{"servicename": "test", "latency": "100", "exclude_health_checks": "false"},
+			expQuery: `
+1 - ((
+	sum(rate(request:ELAPSED_TIME_MS_bucket{job="test-metrics", le="100.0"}[{{.window}}]))
 	/
 	(sum(rate(request:ELAPSED_TIME_MS_count{job="test-metrics"}[{{.window}}])) > 0)
 ) OR on() vector(1))`,
 		},
+
+		"An overridden health check regex should be excluded.": {
+			options: map[string]string{"servicename": "test", "latency": "100", "health_check_apm_tx_regex": "/ping"},
+			expQuery: `
+1 - ((
+	sum(rate(request:ELAPSED_TIME_MS_bucket{job="test-metrics", APM_TRANSACTION!~"/ping", le="100.0"}[{{.window}}]))
+	/
+	(sum(rate(request:ELAPSED_TIME_MS_count{job="test-metrics", APM_TRANSACTION!~"/ping"}[{{.window}}])) > 0)
+) OR on() vector(1))`,
+		},
+
+		"SLO labels needing escaping should be appended to a braced filter.": {
+			meta:   map[string]string{"service": "demandproduct"},
+			labels: map[string]string{"team": `a"b\c`},
+			options: map[string]string{"latency": "250", "filter": `{CLIENT="a"}`, "filter_from_labels": "team",
+				"exclude_health_checks": "false"},
+			expQuery: `
+1 - ((
+	sum(rate(request:ELAPSED_TIME_MS_bucket{job="demandproduct-metrics", CLIENT="a", team="a\"b\\c", le="250.0"}[{{.window}}]))
+	/
+	(sum(rate(request:ELAPSED_TIME_MS_count{job="demandproduct-metrics", CLIENT="a", team="a\"b\\c"}[{{.window}}])) > 0)
+) OR on() vector(1))`,
+		},
+
+		"The client metric profile should render the outbound client metric.": {
+			options: map[string]string{"servicename": "test", "latency": "100", "apm_tx": "/product/full",
+				"metric_profile": "client_request_elapsed_time_ms"},
+			expQuery: `
+1 - ((
+	sum(rate(client_request:ELAPSED_TIME_MS_bucket{job="test-metrics", APM_TRANSACTION="/product/full", le="100.0"}[{{.window}}]))
+	/
+	(sum(rate(client_request:ELAPSED_TIME_MS_count{job="test-metrics", APM_TRANSACTION="/product/full"}[{{.window}}])) > 0)
+) OR on() vector(1))`,
+		},
+
+		"The gRPC metric profile should render its labels and health checks.": {
+			options: map[string]string{"servicename": "test", "latency": "100", "metric_profile": "grpc_request_elapsed_time_ms",
+				"exclude_from_total_http_status_regex": "CANCELLED"},
+			expQuery: `
+1 - ((
+	sum(rate(grpc_request:ELAPSED_TIME_MS_bucket{job="test-metrics", GRPC_STATUS!~"CANCELLED", GRPC_METHOD!~"grpc.health.v1.Health/.*", le="100.0"}[{{.window}}]))
+	/
+	(sum(rate(grpc_request:ELAPSED_TIME_MS_count{job="test-metrics", GRPC_STATUS!~"CANCELLED", GRPC_METHOD!~"grpc.health.v1.Health/.*"}[{{.window}}])) > 0)
+) OR on() vector(1))`,
+		},
+
+		"The gRPC metric profile should validate the status regexes against the gRPC status codes.": {
+			options: map[string]string{"servicename": "test", "latency": "100", "metric_profile": "grpc_request_elapsed_time_ms",
+				"good_http_status_regex": "2.."},
+			expErr: true,
+		},
+
+		"Excluded transactions should be rendered as negative matchers.": {
+			options: map[string]string{"servicename": "test", "latency": "100", "apm_tx_regex": "/product/.*",
+				"apm_tx_exclude": "/product/legacy", "apm_tx_exclude_regex": "/product/internal/.*",
+				"exclude_health_checks": "false"},
+			expQuery: `
+1 - ((
+	sum(rate(request:ELAPSED_TIME_MS_bucket{job="test-metrics", APM_TRANSACTION=~"/product/.*", APM_TRANSACTION!="/product/legacy", APM_TRANSACTION!~"/product/internal/.*", le="100.0"}[{{.window}}]))
+	/
+	(sum(rate(request:ELAPSED_TIME_MS_count{job="test-metrics", APM_TRANSACTION=~"/product/.*", APM_TRANSACTION!="/product/legacy", APM_TRANSACTION!~"/product/internal/.*"}[{{.window}}])) > 0)
+) OR on() vector(1))`,
+		},
+
+		"An invalid apm_tx_exclude_regex should fail.": {
+			options: map[string]string{"servicename": "test", "latency": "100", "apm_tx_exclude_regex": "([xyz"},
+			expErr:  true,
+		},
+
+		"A list of transactions and globs should be rendered as an escaped regex.": {
+			options: map[string]string{"servicename": "test", "latency": "100", "apm_tx": "/product/full, /product.json",
+				"apm_tx_glob": "/product/*/reviews"},
+			expQuery: `
+1 - ((
+	sum(rate(request:ELAPSED_TIME_MS_bucket{job="test-metrics", APM_TRANSACTION=~"/product/full|/product\\.json|/product/[^/]*/reviews", le="100.0"}[{{.window}}]))
+	/
+	(sum(rate(request:ELAPSED_TIME_MS_count{job="test-metrics", APM_TRANSACTION=~"/product/full|/product\\.json|/product/[^/]*/reviews"}[{{.window}}])) > 0)
+) OR on() vector(1))`,
+		},
+
+		"Case-insensitive transactions should be rendered as case-insensitive regexes.": {
+			options: map[string]string{"servicename": "test", "latency": "100", "apm_tx": "/Product/Full",
+				"apm_tx_glob": "/Product/*/Reviews", "apm_tx_case_insensitive": "true"},
+			expQuery: `
+1 - ((
+	sum(rate(request:ELAPSED_TIME_MS_bucket{job="test-metrics", APM_TRANSACTION=~"(?i)/Product/Full|/Product/[^/]*/Reviews", le="100.0"}[{{.window}}]))
+	/
+	(sum(rate(request:ELAPSED_TIME_MS_count{job="test-metrics", APM_TRANSACTION=~"(?i)/Product/Full|/Product/[^/]*/Reviews"}[{{.window}}])) > 0)
+) OR on() vector(1))`,
+		},
+
+		"Case-insensitive matching without transactions should fail.": {
+			options: map[string]string{"servicename": "test", "latency": "100", "apm_tx_case_insensitive": "true"},
+			expErr:  true,
+		},
+
+		"Filter matchers of every operator should be parsed.": {
+			options: map[string]string{"servicename": "test", "latency": "100", "exclude_health_checks": "false",
+				"filter": `{CLIENT!="bot", REGION=~"eu|us", TIER!~"free.*"}`},
+			expQuery: `
+1 - ((
+	sum(rate(request:ELAPSED_TIME_MS_bucket{job="test-metrics", CLIENT!="bot", REGION=~"eu|us", TIER!~"free.*", le="100.0"}[{{.window}}]))
+	/
+	(sum(rate(request:ELAPSED_TIME_MS_count{job="test-metrics", CLIENT!="bot", REGION=~"eu|us", TIER!~"free.*"}[{{.window}}])) > 0)
+) OR on() vector(1))`,
+		},
+
+		"An invalid filter should fail.": {
+			options: map[string]string{"servicename": "test", "latency": "100", "filter": `CLIENT=TRIPADVISOR`},
+			expErr:  true,
+		},
+
+		"Quotes and backslashes in values should be escaped.": {
+			options: map[string]string{"servicename": "test", "latency": "100", "apm_tx": `/product"}`,
+				"apm_tx_exclude_regex": `/product\.json`},
+			expQuery: `
+1 - ((
+	sum(rate(request:ELAPSED_TIME_MS_bucket{job="test-metrics", APM_TRANSACTION="/product\"}", APM_TRANSACTION!~"/product\\.json", le="100.0"}[{{.window}}]))
+	/
+	(sum(rate(request:ELAPSED_TIME_MS_count{job="test-metrics", APM_TRANSACTION="/product\"}", APM_TRANSACTION!~"/product\\.json"}[{{.window}}])) > 0)
+) OR on() vector(1))`,
+		},
+
+		"An invalid servicename should fail.": {
+			options: map[string]string{"servicename": `test-metrics", job="other`, "latency": "100"},
+			expErr:  true,
+		},
+
+		"Regex lint findings should fail with strict regex lint.": {
+			options: map[string]string{"servicename": "test", "latency": "100", "apm_tx_regex": "^/product.*$",
+				"strict_regex_lint": "true"},
+			expErr: true,
+		},
+
+		"Regex lint findings should not fail without strict regex lint.": {
+			options: map[string]string{"servicename": "test", "latency": "100", "apm_tx_regex": "^/product.*$",
+				"exclude_health_checks": "false"},
+			expQuery: `
+1 - ((
+	sum(rate(request:ELAPSED_TIME_MS_bucket{job="test-metrics", APM_TRANSACTION=~"^/product.*$", le="100.0"}[{{.window}}]))
+	/
+	(sum(rate(request:ELAPSED_TIME_MS_count{job="test-metrics", APM_TRANSACTION=~"^/product.*$"}[{{.window}}])) > 0)
+) OR on() vector(1))`,
+		},
+
+		"An exact job should replace the job derived from the servicename.": {
+			options: map[string]string{"job": "test/test-pods", "latency": "100", "exclude_health_checks": "false"},
+			expQuery: `
+1 - ((
+	sum(rate(request:ELAPSED_TIME_MS_bucket{job="test/test-pods", le="100.0"}[{{.window}}]))
+	/
+	(sum(rate(request:ELAPSED_TIME_MS_count{job="test/test-pods"}[{{.window}}])) > 0)
+) OR on() vector(1))`,
+		},
+
+		"A job regex should replace the job derived from the servicename.": {
+			options: map[string]string{"servicename": "test", "job_regex": "test-(metrics|canary)", "latency": "100",
+				"exclude_health_checks": "false"},
+			expQuery: `
+1 - ((
+	sum(rate(request:ELAPSED_TIME_MS_bucket{job=~"test-(metrics|canary)", le="100.0"}[{{.window}}]))
+	/
+	(sum(rate(request:ELAPSED_TIME_MS_count{job=~"test-(metrics|canary)"}[{{.window}}])) > 0)
+) OR on() vector(1))`,
+		},
+
+		"A job suffix should be appended to the servicename.": {
+			options: map[string]string{"servicename": "test", "job_suffix": "-pods", "latency": "100",
+				"exclude_health_checks": "false"},
+			expQuery: `
+1 - ((
+	sum(rate(request:ELAPSED_TIME_MS_bucket{job="test-pods", le="100.0"}[{{.window}}]))
+	/
+	(sum(rate(request:ELAPSED_TIME_MS_count{job="test-pods"}[{{.window}}])) > 0)
+) OR on() vector(1))`,
+		},
+
+		"A job together with a job regex should fail.": {
+			options: map[string]string{"job": "test-metrics", "job_regex": "test-.*", "latency": "100"},
+			expErr:  true,
+		},
+
+		"An unknown metric profile should fail.": {
+			options: map[string]string{"servicename": "test", "latency": "100", "metric_profile": "unknown"},
+			expErr:  true,
+		},
+
+		"A false looking transaction should conflict with a transaction regex.": {
+			options: map[string]string{"servicename": "test", "latency": "100", "apm_tx": "F", "apm_tx_regex": "a.*"},
+			expErr:  true,
+		},
+
+		"A false looking job regex should conflict with a job.": {
+			options: map[string]string{"job": "test-metrics", "job_regex": "0", "latency": "100"},
+			expErr:  true,
+		},
 	}
 
 	for name, test := range tests {
@@ -252,9 +522,361 @@ func TestSLIPlugin(t *testing.T) {
 			if test.expErr {
 				asserts.Error(err)
 			} else if asserts.NoError(err) {
-				asserts.Equal(strings.Trim(test.expQuery, " \n\t"), strings.Trim(gotQuery, " \n\t"))
+				asserts.Equal(strings.Trim(test.expQuery, " \n\t"), strings.Trim(withoutTraceHeader(gotQuery), " \n\t"))
 			}
 		})
 	}
 
 }
+
+func FuzzRenderedSelector(f *testing.F) {
+	f.Add("demandproduct", "/product/full", "", "/product/legacy", `/product\.json`, `CLIENT="TRIPADVISOR"`, "100")
+	f.Add("demandproduct", "/a, /b.json", "", `x", injected="y`, `.*"}`, `CLIENT!~"\\\\"`, "150")
+	f.Add("demand.product", "", "/product/.*", `\"`, "`", `{job="other"}`, "3000")
+	f.Add("demandproduct", `/product"}`, "", "'", "日本", "CLIENT=\"\\n\"", "7")
+
+	f.Fuzz(func(t *testing.T, servicename, apmTx, apmTxRegex, apmTxExclude, apmTxExcludeRegex, filter,
+		latencyOption string) {
+		options := map[string]string{
+			"servicename":          servicename,
+			"apm_tx":               apmTx,
+			"apm_tx_regex":         apmTxRegex,
+			"apm_tx_exclude":       apmTxExclude,
+			"apm_tx_exclude_regex": apmTxExcludeRegex,
+			"filter":               filter,
+			"latency":              latencyOption,
+		}
+		query, err := latency.SLIPlugin(context.TODO(), nil, nil, options)
+		if err != nil {
+			t.Skip()
+		}
+
+		selectors, err := promqltest.ParseSelectors(query)
+		if err != nil {
+			t.Fatalf("%v in query %s", err, query)
+		}
+		if len(selectors) < 2 {
+			t.Fatalf("expected bucket and count selectors in query %s", query)
+		}
+
+		job := promqltest.Matcher{Name: "job", Op: "=", Value: servicename + "-metrics"}
+		var general []promqltest.Matcher
+		var transactions []string
+		for _, transaction := range strings.Split(apmTx, ",") {
+			if transaction = strings.TrimSpace(transaction); transaction != "" {
+				transactions = append(transactions, transaction)
+			}
+		}
+		switch len(transactions) {
+		case 0:
+		case 1:
+			general = append(general, promqltest.Matcher{Name: "APM_TRANSACTION", Op: "=", Value: transactions[0]})
+		default:
+			for i, transaction := range transactions {
+				transactions[i] = regexp.QuoteMeta(transaction)
+			}
+			general = append(general,
+				promqltest.Matcher{Name: "APM_TRANSACTION", Op: "=~", Value: strings.Join(transactions, "|")})
+		}
+		for _, matcher := range []promqltest.Matcher{
+			{Name: "APM_TRANSACTION", Op: "=~", Value: apmTxRegex},
+			{Name: "APM_TRANSACTION", Op: "!=", Value: apmTxExclude},
+			{Name: "APM_TRANSACTION", Op: "!~", Value: apmTxExcludeRegex},
+		} {
+			if matcher.Value != "" {
+				general = append(general, matcher)
+			}
+		}
+
+		count := selectors[len(selectors)-1]
+		for _, selector := range selectors {
+			assert.Equal(t, job, selector[0], query)
+			jobs, buckets := 0, 0
+			for _, matcher := range selector {
+				switch matcher.Name {
+				case "job":
+					jobs++
+				case "le":
+					buckets++
+				}
+			}
+			if !strings.Contains(filter, "job") {
+				assert.Equal(t, 1, jobs, "no job matcher should be injected in query %s", query)
+			}
+			for _, matcher := range general {
+				assert.Contains(t, selector, matcher, query)
+			}
+			assert.Equal(t, len(count), len(selector)-buckets,
+				"the bucket selectors should only add the le matcher in query %s", query)
+		}
+	})
+}
+
+var update = flag.Bool("update", false, "update the golden files of the rendered queries")
+
+func TestSLIPluginGolden(t *testing.T) {
+	tests := map[string]map[string]string{
+		"exact-bucket":    {"servicename": "demandproduct", "apm_tx": "/product/full", "latency": "100"},
+		"between-buckets": {"servicename": "demandproduct", "apm_tx": "/product/full", "latency": "150"},
+		"all-options": {
+			"servicename":            "demandproduct",
+			"latency":                "175",
+			"apm_tx":                 "/product/full",
+			"filter":                 `CLIENT="TRIPADVISOR"`,
+			"success_filter":         `REQUEST_SIZE_BUCKET="FIFTY"`,
+			"good_http_status_regex": "2..",
+			"exclude_filter":         `REGION="test"`,
+		},
+	}
+
+	for name, options := range tests {
+		t.Run(name, func(t *testing.T) {
+			asserts := assert.New(t)
+			golden := filepath.Join("testdata", name+".golden")
+
+			gotQuery, err := latency.SLIPlugin(context.TODO(), nil, nil, options)
+			if !asserts.NoError(err) {
+				return
+			}
+
+			if *update {
+				asserts.NoError(os.WriteFile(golden, []byte(gotQuery), 0o600))
+			}
+
+			expQuery, err := os.ReadFile(golden)
+			if asserts.NoError(err) {
+				asserts.Equal(string(expQuery), gotQuery)
+			}
+		})
+	}
+}
+
+func TestSLIPluginLatencyValidationErrors(t *testing.T) {
+	tests := map[string]struct {
+		latency string
+		expErr  error
+	}{
+		"A missing latency should be reported as missing.": {
+			latency: "",
+			expErr:  latency.ErrMissingMandatory,
+		},
+		"A non numeric latency should be reported as invalid.": {
+			latency: "fast",
+			expErr:  latency.ErrInvalidValue,
+		},
+		"A massive latency should be reported as out of range.": {
+			latency: "5000000",
+			expErr:  latency.ErrOutOfRange,
+		},
+		"A latency below the lowest bucket should be reported as out of range.": {
+			latency: "3",
+			expErr:  latency.ErrOutOfRange,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			asserts := assert.New(t)
+
+			_, err := latency.SLIPlugin(context.TODO(), nil, nil, map[string]string{
+				"servicename":  "test",
+				"apm_tx_regex": "([xyz",
+				"latency":      test.latency,
+			})
+
+			var validationErrors latency.ValidationErrors
+			if asserts.True(errors.As(err, &validationErrors)) {
+				asserts.Len(validationErrors, 2)
+			}
+			asserts.True(errors.Is(err, latency.ErrInvalidRegex))
+			asserts.True(errors.Is(err, test.expErr))
+		})
+	}
+}
+
+func TestAdvise(t *testing.T) {
+	tests := map[string]struct {
+		latency string
+		exp     []latency.Advisory
+	}{
+		"A latency on a bucket should not result in advisories.": {
+			latency: "250",
+		},
+		"A latency close to a bucket should not result in advisories.": {
+			latency: "300",
+		},
+		"A latency far from any bucket should be advised against.": {
+			latency: "1500",
+			exp: []latency.Advisory{{Code: latency.AdvisoryLatencyFarFromBucket, Option: "latency",
+				Message: "is 33% away from the closest bucket 1000, the good requests are interpolated linearly " +
+					"between the buckets 1000 and 2000, prefer a latency close to a bucket"}},
+		},
+		"An invalid latency should be left to the validation.": {
+			latency: "fast",
+		},
+		"A latency below the lowest bucket should be left to the validation.": {
+			latency: "3",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			advisories := latency.Advise(map[string]string{"servicename": "demandproduct", "apm_tx": "/product/full",
+				"latency": test.latency})
+			assert.Equal(t, test.exp, advisories)
+		})
+	}
+}
+
+func TestAdviseGeneralOptions(t *testing.T) {
+	tests := map[string]struct {
+		options map[string]string
+		exp     []string
+	}{
+		"Not setting any transaction should be advised against.": {
+			options: map[string]string{"servicename": "demandproduct", "latency": "250"},
+			exp: []string{"missing-apm-tx: option 'apm_tx': is not set, " +
+				"an SLO should usually be limited to the APM_TRANSACTIONs of a single use case"},
+		},
+		"Regex lint warnings should be advisories.": {
+			options: map[string]string{"servicename": "demandproduct", "apm_tx_regex": "^/product.*", "latency": "250"},
+			exp:     []string{"regex-lint: option 'apm_tx_regex': has redundant anchors, Prometheus fully anchors regex matchers"},
+		},
+		"Filters duplicating first-class options should be advised against.": {
+			options: map[string]string{"servicename": "demandproduct", "apm_tx": "/product/full", "latency": "250",
+				"filter": `APM_TRANSACTION!="/ping"`},
+			exp: []string{`filter-duplicates-option: option 'filter': matcher APM_TRANSACTION!="/ping" duplicates a ` +
+				"first-class option, use apm_tx, apm_tx_glob, apm_tx_regex, apm_tx_exclude or apm_tx_exclude_regex instead"},
+		},
+		"The transaction label of the metric profile should be advised on.": {
+			options: map[string]string{"servicename": "demandproduct", "latency": "250",
+				"metric_profile": "grpc_request_elapsed_time_ms"},
+			exp: []string{"missing-apm-tx: option 'apm_tx': is not set, " +
+				"an SLO should usually be limited to the GRPC_METHODs of a single use case"},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			var advisories []string
+			for _, advisory := range latency.Advise(test.options) {
+				advisories = append(advisories, advisory.String())
+			}
+			assert.Equal(t, test.exp, advisories)
+		})
+	}
+}
+
+func TestQueryBuilder(t *testing.T) {
+	asserts := assert.New(t)
+
+	query, err := latency.NewQueryBuilder("demandproduct").
+		ApmTx("/product/full").
+		SuccessFilter(latency.LabelMatcher{Name: "o", Op: "=", Value: "g"}).
+		Latency(300).
+		Build()
+
+	if asserts.NoError(err) {
+		expQuery, err := latency.SLIPlugin(context.TODO(), nil, nil, map[string]string{
+			"servicename": "demandproduct", "apm_tx": "/product/full", "success_filter": `o="g"`, "latency": "300"})
+		asserts.NoError(err)
+		asserts.Equal(expQuery, query)
+	}
+
+	parsed, err := latency.ParseOptions(map[string]string{"servicename": "demandproduct", "latency": " 250 "})
+	if asserts.NoError(err) {
+		asserts.Equal(250, parsed.Latency)
+		asserts.Equal(map[string]string{"servicename": "demandproduct", "latency": "250"}, parsed.Map())
+	}
+
+	_, err = latency.NewQueryBuilder("demandproduct").Build()
+	asserts.ErrorIs(err, latency.ErrMissingMandatory)
+}
+
+func TestOptionSchema(t *testing.T) {
+	asserts := assert.New(t)
+
+	asserts.Equal(append([]string{"latency"}, latency.GeneralOptions...), latency.OptionNames(latency.OptionSchema))
+	for _, spec := range latency.OptionSchema {
+		if spec.Type == latency.IntOption {
+			_, err := latency.ParseOptions(map[string]string{"servicename": "demandproduct", spec.Name: spec.Example})
+			asserts.NoError(err, spec.Name)
+			asserts.Equal(latency.TopBucket, spec.Maximum)
+		}
+	}
+}
+
+func TestExplain(t *testing.T) {
+	tests := map[string]struct {
+		options map[string]string
+		exp     string
+	}{
+		"A latency between buckets should explain the interpolation.": {
+			options: map[string]string{"servicename": "demandproduct", "apm_tx": "/product/full", "latency": "150",
+				"good_http_status_regex": "2.."},
+			exp: "Good = requests to demandproduct-metrics, APM_TRANSACTION=/product/full, " +
+				"with latency ≤150ms (estimated between the 100ms and 250ms buckets at ratio 0.33); good: 2xx; " +
+				"Total = the same requests with any status and latency; " +
+				"without requests in the window the SLI reports no errors.",
+		},
+		"A latency on a bucket should not be estimated.": {
+			options: map[string]string{"servicename": "demandproduct", "apm_tx": "/product/full", "latency": "250"},
+			exp: "Good = requests to demandproduct-metrics, APM_TRANSACTION=/product/full, " +
+				"with latency ≤250ms (the 250ms bucket); Total = the same requests with any latency; " +
+				"without requests in the window the SLI reports no errors.",
+		},
+		"Excluded and good status codes should be explained as one list each.": {
+			options: map[string]string{"servicename": "demandproduct", "apm_tx": "/product/full", "latency": "250",
+				"exclude_from_total_http_status_regex": "4..", "bad_http_status_regex": "5.."},
+			exp: "Good = requests to demandproduct-metrics, APM_TRANSACTION=/product/full, excluding 4xx, " +
+				"with latency ≤250ms (the 250ms bucket); good: 1xx, 2xx, 3xx; " +
+				"Total = the same requests with any status and latency; " +
+				"without requests in the window the SLI reports no errors.",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			explanation, err := latency.Explain(test.options)
+			assert.NoError(t, err)
+			assert.Equal(t, test.exp, explanation)
+		})
+	}
+
+	_, err := latency.Explain(map[string]string{"servicename": "demandproduct"})
+	assert.Error(t, err)
+	_, err = latency.Explain(map[string]string{"servicename": "demandproduct", "latency": "3"})
+	assert.ErrorIs(t, err, latency.ErrOutOfRange)
+}
+
+// withoutTraceHeader removes the trace header comment lines of a query.
+func withoutTraceHeader(query string) string {
+	var lines []string
+	for _, line := range strings.Split(query, "\n") {
+		if !strings.HasPrefix(line, "#") {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestTraceHeader(t *testing.T) {
+	asserts := assert.New(t)
+
+	query, err := latency.SLIPlugin(context.TODO(), nil, nil,
+		map[string]string{"servicename": "demandproduct", "apm_tx": "/product/full", "latency": "150"})
+	asserts.NoError(err)
+	asserts.Contains(query, "# plugin: viator-sloth-plugins/request_elapsed_time_ms/latency\n")
+	asserts.Contains(query, "# note: latency 150ms is estimated between the 100ms and 250ms buckets at ratio 0.33\n")
+
+	query, err = latency.SLIPlugin(context.TODO(), nil, nil,
+		map[string]string{"servicename": "demandproduct", "apm_tx": "/product/full", "latency": "250"})
+	asserts.NoError(err)
+	asserts.Contains(query, "# plugin: viator-sloth-plugins/request_elapsed_time_ms/latency\n")
+	asserts.NotContains(query, "# note:")
+
+	query, err = latency.SLIPlugin(context.TODO(), nil, nil,
+		map[string]string{"servicename": "demandproduct", "latency": "150", "trace_header": "false"})
+	asserts.NoError(err)
+	asserts.NotContains(query, "#")
+}
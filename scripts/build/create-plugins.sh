@@ -67,6 +67,9 @@ find .  -type f -name "*.go"  \
   newPath="${dir}/${pluginFilename}"
   cp -af "${plugin}" "${newPath}"
   cp -af "$(dirname ${plugin})/README.md" "${dir}/"
+  if [[ -d "$(dirname ${plugin})/testdata" ]]; then
+    cp -af "$(dirname ${plugin})/testdata" "${dir}/"
+  fi
 
   # if a file requires additional processing then process it
   if $(grep -q "${FILTER_TEMPLATE_MARKER}" "${newPath}" ); then
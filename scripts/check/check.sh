@@ -1,3 +1,8 @@
 #!/usr/bin/env sh
 
-./scripts/check/lint.sh
\ No newline at end of file
+set -o errexit
+
+./scripts/check/lint.sh
+./scripts/check/shared-code.sh
+go run ./cmd/slothplug readme -check
+./scripts/check/integration-test.sh
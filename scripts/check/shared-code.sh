@@ -0,0 +1,41 @@
+#!/usr/bin/env sh
+
+set -o errexit
+set -o nounset
+
+# The plugins can only be single files, so the shared code is duplicated in every plugin file,
+# between the imports and the SLIPluginVersion constant. This check fails when the copies drift apart.
+
+PLUGINS_PATH=./plugins/request_elapsed_time_ms
+REFERENCE="${PLUGINS_PATH}/availability/plugin.go"
+
+sharedCode ()
+{
+  # prints the lines after the imports, up to the `const (` line of the SLIPluginVersion constant
+  awk '
+    /SLIPluginVersion is/ { exit }
+    shared && buffered { print previous }
+    shared { previous = $0; buffered = 1 }
+    /^\)$/ { shared = 1 }
+  ' "${1}"
+}
+
+reference_code=$(mktemp)
+trap 'rm -f "${reference_code}"' EXIT
+sharedCode "${REFERENCE}" > "${reference_code}"
+if [ ! -s "${reference_code}" ]; then
+  echo "no shared code found in '${REFERENCE}'" >&2
+  exit 1
+fi
+
+exit_code=0
+for plugin in "${PLUGINS_PATH}"/*/plugin.go; do
+  if [ "${plugin}" = "${REFERENCE}" ]; then
+    continue
+  fi
+  if ! sharedCode "${plugin}" | diff -u "${reference_code}" - ; then
+    echo "the shared code of '${plugin}' differs from '${REFERENCE}'" >&2
+    exit_code=1
+  fi
+done
+exit ${exit_code}